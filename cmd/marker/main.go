@@ -15,6 +15,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
@@ -29,17 +30,23 @@ import (
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/cache"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/marker"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
 const (
 	UnixSocketType          = "unix"
 	TcpSocketType           = "tcp"
+	SslSocketType           = "ssl"
 	SocketConnectionTimeout = time.Minute
 )
 
 func main() {
 	nodeName := flag.String("node-name", "", "name of kubernetes node")
 	ovsSocket := flag.String("ovs-socket", "", "address of openvswitch database connection")
+	sslCACert := flag.String("ovs-ssl-ca-cert", "", "path of the CA certificate used to verify an ssl ovs-socket")
+	sslCert := flag.String("ovs-ssl-cert", "", "path of the client certificate used to connect to an ssl ovs-socket")
+	sslKey := flag.String("ovs-ssl-key", "", "path of the client private key used to connect to an ssl ovs-socket")
 
 	const defaultUpdateInterval = 60 * time.Second
 	updateInterval := flag.Int("update-interval", int(defaultUpdateInterval.Seconds()), fmt.Sprintf("interval between updates in seconds, %d by default", int(defaultUpdateInterval.Seconds())))
@@ -47,6 +54,14 @@ func main() {
 	const defaultReconcileInterval = 10 * time.Minute
 	reconcileInterval := flag.Int("reconcile-interval", int(defaultReconcileInterval.Minutes()), fmt.Sprintf("interval between node bridges reconcile in minutes, %d by default", int(defaultReconcileInterval.Minutes())))
 
+	const defaultErrorPortsCleanInterval = 60 * time.Second
+	errorPortsCleanInterval := flag.Int("error-ports-clean-interval", int(defaultErrorPortsCleanInterval.Seconds()),
+		fmt.Sprintf("interval between removals of the ovs-cni ports in error state in seconds, 0 disables them, %d by default", int(defaultErrorPortsCleanInterval.Seconds())))
+
+	const defaultErrorPortsGracePeriod = 60 * time.Second
+	errorPortsGracePeriod := flag.Int("error-ports-grace-period", int(defaultErrorPortsGracePeriod.Seconds()),
+		fmt.Sprintf("time in seconds a port has to be in error state before being removed, %d by default", int(defaultErrorPortsGracePeriod.Seconds())))
+
 	const healthCheckFile = "/tmp/healthy"
 
 	const defaultHealthCheckInterval = 60 * time.Second
@@ -66,18 +81,23 @@ func main() {
 	if err != nil {
 		glog.Fatalf("Failed to parse ovs socket: %v", err)
 	}
-	if err = validateOvsSocketConnection(socketType, address); err != nil {
+	sslConfig := types.SSLConfig{CACert: *sslCACert, Cert: *sslCert, Key: *sslKey}
+	if err = validateOvsSocketConnection(socketType, address, sslConfig); err != nil {
 		glog.Fatal("Failed to connect to ovs: %v", err)
 	}
 	endpoint := fmt.Sprintf("%s:%s", socketType, address)
 
-	markerApp, err := marker.NewMarker(*nodeName, endpoint)
+	markerApp, err := marker.NewMarker(*nodeName, types.OvsdbConfig{SocketFile: endpoint, SSLConfig: sslConfig})
 	if err != nil {
 		glog.Fatalf("Failed to create a new marker object: %v", err)
 	}
 
 	go keepAlive(healthCheckFile, *healthCheckInterval)
 
+	if *errorPortsCleanInterval > 0 {
+		go cleanErrorPorts(markerApp, *errorPortsCleanInterval, *errorPortsGracePeriod)
+	}
+
 	markerCache := cache.Cache{}
 	wait.JitterUntil(func() {
 		jitteredReconcileInterval := wait.Jitter(time.Duration(*reconcileInterval)*time.Minute, 1.2)
@@ -122,6 +142,22 @@ func keepAlive(healthCheckFile string, healthCheckInterval int) {
 	}, time.Duration(healthCheckInterval)*time.Second)
 }
 
+// cleanErrorPorts periodically removes the ports created by ovs-cni whose
+// interface stays in error state, e.g. after a container namespace was
+// removed before DEL
+func cleanErrorPorts(markerApp *marker.Marker, cleanInterval, gracePeriod int) {
+	wait.Forever(func() {
+		removed, err := markerApp.CleanErrorPorts(time.Duration(gracePeriod) * time.Second)
+		if err != nil {
+			glog.Errorf("CleanErrorPorts failed: %v", err)
+			return
+		}
+		for _, port := range removed {
+			glog.Infof("Removed port %s of bridge %s, its interface was in error state: %s", port.Name, port.Bridge, port.Error)
+		}
+	}, time.Duration(cleanInterval)*time.Second)
+}
+
 /*
 takes an OVS socket string and returns the socket
 type, address, and any parsing error.
@@ -139,7 +175,7 @@ func parseOvsSocket(ovsSocket *string) (string, string, error) {
 		address = *ovsSocket
 	} else {
 		socketType = ovsSocketTokens[0]
-		if socketType == TcpSocketType {
+		if socketType == TcpSocketType || socketType == SslSocketType {
 			if len(ovsSocketTokens) != 3 {
 				return "", "", fmt.Errorf("failed to parse OVS %s socket, must be in this format %s:<host>:<port>", socketType, socketType)
 			}
@@ -153,20 +189,28 @@ func parseOvsSocket(ovsSocket *string) (string, string, error) {
 	return socketType, address, nil
 }
 
-func validateOvsSocketConnection(socketType, address string) error {
-	validator, err := getOvsSocketValidator(socketType)
+func validateOvsSocketConnection(socketType, address string, sslConfig types.SSLConfig) error {
+	validator, err := getOvsSocketValidator(socketType, sslConfig)
 	if err != nil {
 		return err
 	}
 	return validator(address)
 }
 
-func getOvsSocketValidator(socketType string) (func(string) error, error) {
+func getOvsSocketValidator(socketType string, sslConfig types.SSLConfig) (func(string) error, error) {
 	switch socketType {
 	case UnixSocketType:
 		return validateOvsUnixConnection, nil
 	case TcpSocketType:
 		return validateOvsTcpConnection, nil
+	case SslSocketType:
+		tlsConfig, err := ovsdb.NewTLSConfig(sslConfig)
+		if err != nil {
+			return nil, err
+		}
+		return func(address string) error {
+			return validateOvsSslConnection(address, tlsConfig)
+		}, nil
 	default:
 		return nil, fmt.Errorf("unsupported ovs socket type: %s", socketType)
 	}
@@ -210,3 +254,20 @@ func validateOvsTcpConnection(address string) error {
 		return fmt.Errorf("unexpected error when connecting to %s: %v", address, err)
 	}
 }
+
+func validateOvsSslConnection(address string, tlsConfig *tls.Config) error {
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: SocketConnectionTimeout}, Config: tlsConfig}
+	conn, err := dialer.Dial(TcpSocketType, address)
+	if err == nil {
+		glog.Info("Successfully connected to SSL socket")
+		if err := conn.Close(); err != nil {
+			return fmt.Errorf("failed to close SSL connection to %s: %v", address, err)
+		}
+		return nil
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return fmt.Errorf("connection to %s timed out", address)
+	}
+	return fmt.Errorf("ssl connection to %s failed: %v", address, err)
+}
@@ -24,8 +24,10 @@ import (
 
 func main() {
 	skel.PluginMainFuncs(skel.CNIFuncs{
-		Add:   plugin.CmdAdd,
-		Check: plugin.CmdCheck,
-		Del:   plugin.CmdDel,
+		Add:    plugin.CmdAdd,
+		Check:  plugin.CmdCheck,
+		Del:    plugin.CmdDel,
+		GC:     plugin.CmdGC,
+		Status: plugin.CmdStatus,
 	}, version.All, buildversion.BuildString("OVS bridge"))
 }
@@ -15,13 +15,16 @@
 package common
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,22 +38,42 @@ import (
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/ns"
+	netv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/deviceinfo"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/portsecurity"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
 const (
-	portTypeAccess = "access"
-	portTypeTrunk  = "trunk"
-	highestVlanID  = 4095
+	portTypeAccess         = "access"
+	portTypeTrunk          = "trunk"
+	portTypeNativeTagged   = "native-tagged"
+	portTypeNativeUntagged = "native-untagged"
+	portTypeDot1qTunnel    = "dot1q-tunnel"
+	highestVlanID          = 4095
+	// minimum MTU of an IPv4 link
+	minMTU = 68
+)
+
+// Keys of the external_ids recording the pod and the addresses of an
+// attachment, the Port ones are named after contPodUid, the Interface ones
+// follow the OVS integration guide and ovn-kubernetes
+const (
+	podNamespaceExternalID = "contPodNamespace"
+	podNameExternalID      = "contPodName"
+	attachedMacExternalID  = "attached-mac"
+	ipAddressesExternalID  = "ip_addresses"
 )
 
 type OvsPortConfig struct {
 	Type    string
 	Trunks  []uint
 	VlanTag uint
+	QinQ    ovsdb.PortQinQ
+	QoS     ovsdb.PortQoS
 }
 
 func GetEnvArgs(envArgsString string) (*types.EnvArgs, error) {
@@ -105,6 +128,16 @@ func ApplyConfArgsFallback(netconf *types.NetConf, mac, ovnPort *string) {
 	}
 }
 
+// ApplyRuntimeConfigMac sets mac to the MAC address requested through the
+// "mac" runtime capability. As in the reference bridge plugin, it takes
+// precedence over CNI_ARGS and args.cni.
+func ApplyRuntimeConfigMac(netconf *types.NetConf, mac *string) {
+	if netconf == nil || netconf.RuntimeConfig == nil || netconf.RuntimeConfig.Mac == "" {
+		return
+	}
+	*mac = netconf.RuntimeConfig.Mac
+}
+
 // decodeArgString decodes a JSON-encoded args.cni value as a string. Non-string
 // types (numbers, arrays, objects) return ok=false rather than an error, so a
 // foreign entry like `"ips": ["1.2.3.4/24"]` is ignored without aborting.
@@ -119,6 +152,182 @@ func decodeArgString(raw json.RawMessage) (string, bool) {
 	return s, true
 }
 
+// vlanArgs is the VLAN configuration requested by a pod through args
+type vlanArgs struct {
+	vlan     *uint
+	trunk    []*types.Trunk
+	vlanMode string
+}
+
+// ApplyVlanArgs overrides the VLAN configuration of netconf with the vlan,
+// trunk and vlanMode requested through CNI_ARGS (Vlan, Trunk, VlanMode) or,
+// when CNI_ARGS doesn't set them, args.cni. Requesting vlan or trunk replaces
+// both the vlan and trunk of netconf. Requests are only accepted when
+// netconf.AllowedVlans is set and the requested VLANs are allowed, the ones
+// kept from netconf aren't checked.
+func ApplyVlanArgs(netconf *types.NetConf, envArgs *types.EnvArgs) error {
+	requested, err := getVlanArgs(netconf, envArgs)
+	if err != nil {
+		return err
+	}
+	if requested.vlan == nil && requested.trunk == nil && requested.vlanMode == "" {
+		return nil
+	}
+	if len(netconf.AllowedVlans) == 0 {
+		return fmt.Errorf("vlan, trunk and vlanMode can't be requested through args: allowedVlans isn't set")
+	}
+	allowedVlans, err := SplitVlanIds(netconf.AllowedVlans)
+	if err != nil {
+		return fmt.Errorf("allowedVlans: %v", err)
+	}
+
+	if requested.vlan != nil || requested.trunk != nil {
+		netconf.VlanTag = requested.vlan
+		netconf.Trunk = requested.trunk
+	}
+	if requested.vlanMode != "" {
+		netconf.VlanMode = requested.vlanMode
+	}
+
+	portCfg, err := ParseOvsPortConfig(netconf)
+	if err != nil {
+		return err
+	}
+	return checkAllowedVlans(portCfg, requested, allowedVlans)
+}
+
+// getVlanArgs returns the VLAN configuration requested through CNI_ARGS,
+// falling back to args.cni for each key CNI_ARGS doesn't set
+func getVlanArgs(netconf *types.NetConf, envArgs *types.EnvArgs) (*vlanArgs, error) {
+	requested := &vlanArgs{}
+	if envArgs != nil {
+		if envArgs.Vlan != "" {
+			vlan, err := parseVlanArg(string(envArgs.Vlan))
+			if err != nil {
+				return nil, err
+			}
+			requested.vlan = &vlan
+		}
+		if envArgs.Trunk != "" {
+			trunk, err := parseTrunkArg(string(envArgs.Trunk))
+			if err != nil {
+				return nil, err
+			}
+			requested.trunk = trunk
+		}
+		requested.vlanMode = string(envArgs.VlanMode)
+	}
+	if netconf.Args == nil {
+		return requested, nil
+	}
+
+	for k, raw := range netconf.Args.Cni {
+		switch strings.ToLower(k) {
+		case "vlan":
+			if requested.vlan != nil {
+				continue
+			}
+			s, ok := decodeArgString(raw)
+			if !ok {
+				// a JSON number
+				s = string(raw)
+			}
+			vlan, err := parseVlanArg(s)
+			if err != nil {
+				return nil, err
+			}
+			requested.vlan = &vlan
+		case "trunk":
+			if requested.trunk != nil {
+				continue
+			}
+			var trunk []*types.Trunk
+			if err := json.Unmarshal(raw, &trunk); err != nil {
+				s, ok := decodeArgString(raw)
+				if !ok {
+					return nil, fmt.Errorf("invalid trunk arg %s", string(raw))
+				}
+				if trunk, err = parseTrunkArg(s); err != nil {
+					return nil, err
+				}
+			}
+			requested.trunk = trunk
+		case "vlanmode":
+			if requested.vlanMode != "" {
+				continue
+			}
+			vlanMode, ok := decodeArgString(raw)
+			if !ok {
+				return nil, fmt.Errorf("invalid vlanMode arg %s", string(raw))
+			}
+			requested.vlanMode = vlanMode
+		}
+	}
+	return requested, nil
+}
+
+// parseVlanArg parses a VLAN ID requested through args
+func parseVlanArg(s string) (uint, error) {
+	vlan, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+	if err != nil || vlan > highestVlanID {
+		return 0, fmt.Errorf("invalid vlan arg %q", s)
+	}
+	return uint(vlan), nil
+}
+
+// parseTrunkArg parses a comma separated list of VLAN IDs and ranges, e.g.
+// "10,20-30", requested through args
+func parseTrunkArg(s string) ([]*types.Trunk, error) {
+	var trunk []*types.Trunk
+	for _, item := range strings.Split(s, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		minID, err := parseVlanArg(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid trunk arg %q", s)
+		}
+		if len(bounds) == 1 {
+			trunk = append(trunk, &types.Trunk{ID: &minID})
+			continue
+		}
+		maxID, err := parseVlanArg(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid trunk arg %q", s)
+		}
+		trunk = append(trunk, &types.Trunk{MinID: &minID, MaxID: &maxID})
+	}
+	return trunk, nil
+}
+
+// checkAllowedVlans checks that the VLANs requested through args are allowed
+// on the port. A trunk port without trunks carries all VLANs, which is never
+// allowed.
+func checkAllowedVlans(portCfg *OvsPortConfig, requested *vlanArgs, allowedVlans []uint) error {
+	allowed := make(map[uint]bool, len(allowedVlans))
+	for _, vlan := range allowedVlans {
+		allowed[vlan] = true
+	}
+
+	if requested.vlan != nil && !allowed[*requested.vlan] {
+		return fmt.Errorf("vlan %d isn't allowed", *requested.vlan)
+	}
+	if portCfg.Type == portTypeAccess || portCfg.Type == portTypeDot1qTunnel {
+		return nil
+	}
+	if len(portCfg.Trunks) == 0 {
+		return fmt.Errorf("a %s port carrying all VLANs isn't allowed", portCfg.Type)
+	}
+	if requested.trunk == nil {
+		return nil
+	}
+	// the requested trunk replaced the one of the configuration
+	for _, vlan := range portCfg.Trunks {
+		if !allowed[vlan] {
+			return fmt.Errorf("trunk vlan %d isn't allowed", vlan)
+		}
+	}
+	return nil
+}
+
 // IsOvsHardwareOffloadEnabled when device id is set, then ovs hardware offload
 // is enabled.
 func IsOvsHardwareOffloadEnabled(deviceID string) bool {
@@ -171,6 +380,10 @@ func SplitVlanIds(trunks []*types.Trunk) ([]uint, error) {
 }
 
 func ParseOvsPortConfig(netconf *types.NetConf) (*OvsPortConfig, error) {
+	if netconf.VlanMode != "" {
+		return parseOvsPortVlanMode(netconf)
+	}
+
 	var vlanTagNum uint = 0
 	trunks := make([]uint, 0)
 	portType := portTypeAccess
@@ -191,38 +404,308 @@ func ParseOvsPortConfig(netconf *types.NetConf) (*OvsPortConfig, error) {
 		Type:    portType,
 		Trunks:  trunks,
 		VlanTag: vlanTagNum,
+		QoS:     GetPortQoS(netconf),
 	}, nil
 }
 
+// parseOvsPortVlanMode returns the port config for an explicitly requested
+// vlanMode
+func parseOvsPortVlanMode(netconf *types.NetConf) (*OvsPortConfig, error) {
+	portCfg := &OvsPortConfig{
+		Type:   netconf.VlanMode,
+		Trunks: make([]uint, 0),
+		QoS:    GetPortQoS(netconf),
+	}
+
+	switch netconf.VlanMode {
+	case portTypeAccess, portTypeNativeTagged, portTypeNativeUntagged, portTypeDot1qTunnel:
+		if netconf.VlanTag == nil {
+			return nil, fmt.Errorf("vlan is required with vlanMode %s", netconf.VlanMode)
+		}
+		if *netconf.VlanTag > highestVlanID {
+			return nil, fmt.Errorf("incorrect vlan parameter")
+		}
+		portCfg.VlanTag = *netconf.VlanTag
+	case portTypeTrunk:
+		if netconf.VlanTag != nil {
+			return nil, fmt.Errorf("vlan can't be set with vlanMode %s", netconf.VlanMode)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported vlanMode %s", netconf.VlanMode)
+	}
+
+	if len(netconf.Trunk) > 0 {
+		if netconf.VlanMode == portTypeAccess || netconf.VlanMode == portTypeDot1qTunnel {
+			return nil, fmt.Errorf("trunk can't be set with vlanMode %s", netconf.VlanMode)
+		}
+		trunkVlanIds, err := SplitVlanIds(netconf.Trunk)
+		if err != nil {
+			return nil, err
+		}
+		portCfg.Trunks = append(portCfg.Trunks, trunkVlanIds...)
+	}
+
+	if netconf.VlanMode != portTypeDot1qTunnel {
+		if len(netconf.Cvlans) > 0 || netconf.QinqEthtype != "" {
+			return nil, fmt.Errorf("cvlans and qinqEthtype can only be set with vlanMode %s", portTypeDot1qTunnel)
+		}
+		return portCfg, nil
+	}
+
+	if len(netconf.Cvlans) > 0 {
+		cvlans, err := SplitVlanIds(netconf.Cvlans)
+		if err != nil {
+			return nil, fmt.Errorf("cvlans: %v", err)
+		}
+		portCfg.QinQ.Cvlans = cvlans
+	}
+	switch netconf.QinqEthtype {
+	case "", "802.1ad", "802.1q":
+		portCfg.QinQ.Ethtype = netconf.QinqEthtype
+	default:
+		return nil, fmt.Errorf("unsupported qinqEthtype %s", netconf.QinqEthtype)
+	}
+
+	return portCfg, nil
+}
+
+// GetPortQoS returns bandwidth limits of the port. Limits set through the
+// "bandwidth" runtime capability take precedence over the netconf ones.
+func GetPortQoS(netconf *types.NetConf) ovsdb.PortQoS {
+	qos := ovsdb.PortQoS{
+		IngressPolicingRate:  netconf.IngressPolicingRate,
+		IngressPolicingBurst: netconf.IngressPolicingBurst,
+		EgressRate:           netconf.EgressShapingRate,
+		EgressBurst:          netconf.EgressShapingBurst,
+	}
+
+	if netconf.RuntimeConfig != nil && netconf.RuntimeConfig.Bandwidth != nil {
+		bw := netconf.RuntimeConfig.Bandwidth
+		// traffic leaving the container is policed when received by OVS,
+		// ingress policing is configured in kbps and kb
+		if bw.EgressRate != 0 {
+			qos.IngressPolicingRate = uint(bw.EgressRate / 1000)
+			qos.IngressPolicingBurst = uint(bw.EgressBurst / 1000)
+		}
+		// traffic entering the container is shaped when sent by OVS
+		if bw.IngressRate != 0 {
+			qos.EgressRate = bw.IngressRate
+			qos.EgressBurst = bw.IngressBurst
+		}
+	}
+
+	// a burst is meaningless without a rate and is not configured
+	if qos.IngressPolicingRate == 0 {
+		qos.IngressPolicingBurst = 0
+	}
+	if qos.EgressRate == 0 {
+		qos.EgressBurst = 0
+	}
+
+	return qos
+}
+
 // GetBridgeName checks the bridgeName and ovnPort variables to resolve
 // bridge name to defaults if needed
 func GetBridgeName(bridgeName, ovnPort string) (string, error) {
 	if bridgeName != "" {
 		return bridgeName, nil
 	} else if bridgeName == "" && ovnPort != "" {
-		return "br-int", nil
+		return config.OvnIntegrationBridge, nil
 	}
 
 	return "", fmt.Errorf("failed to get bridge name")
 }
 
-// CleanPorts removes all ports whose interfaces have an error.
-func CleanPorts(ovsDriver *ovsdb.OvsBridgeDriver) error {
-	ifaces, err := ovsDriver.FindInterfacesWithError()
+// CheckPortSecurityOvnPort fails when port security is requested for an
+// ovnPort, whose traffic has to go through the OVN pipeline rather than the
+// NORMAL action of the port security rules
+func CheckPortSecurityOvnPort(netconf *types.NetConf, ovnPort string) error {
+	if netconf.PortSecurity && ovnPort != "" {
+		return fmt.Errorf("portSecurity can't be used with ovnPort %s", ovnPort)
+	}
+	return nil
+}
+
+// ResolveWaitForOvnInstalled sets whether ADD waits for ovn-controller to
+// install the ovnPort. Unless configured, it does when the port is attached
+// to br-int because no bridge is set, so it has to be called before the
+// bridge name is resolved.
+func ResolveWaitForOvnInstalled(netconf *types.NetConf, ovnPort string) {
+	wait := false
+	if ovnPort != "" {
+		if netconf.WaitForOvnInstalled != nil {
+			wait = *netconf.WaitForOvnInstalled
+		} else {
+			wait = netconf.BrName == ""
+		}
+	}
+	netconf.WaitForOvnInstalled = &wait
+}
+
+// GetOvnPortVersion returns the version of the ovnPort the interface is
+// bound to, the pod UID when ADD waits for ovn-controller to install it
+func GetOvnPortVersion(netconf *types.NetConf, contPodUid string) string {
+	if netconf.WaitForOvnInstalled == nil || !*netconf.WaitForOvnInstalled {
+		return ""
+	}
+	return contPodUid
+}
+
+// WaitOvnInstalled waits for ovn-controller to install the ovnPort of the
+// interface, if resolved to by ResolveWaitForOvnInstalled
+func WaitOvnInstalled(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, hostIfaceName string) error {
+	if netconf.WaitForOvnInstalled == nil || !*netconf.WaitForOvnInstalled {
+		return nil
+	}
+
+	timeout := time.Duration(netconf.OvnInstalledTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := ovsDriver.WaitOvnInstalled(ctx, hostIfaceName); err != nil {
+		return fmt.Errorf("The OF port %s is not installed by ovn-controller, try increasing the ovnInstalledTimeout config parameter: %v", hostIfaceName, err)
+	}
+	return nil
+}
+
+// EnsureBridge creates the bridge with the uplink, datapath type and fail
+// mode from netconf unless it already exists.
+func EnsureBridge(ovsDriver *ovsdb.OvsDriver, bridgeName string, netconf *types.NetConf) error {
+	found, err := ovsDriver.IsBridgePresent(bridgeName)
 	if err != nil {
-		return fmt.Errorf("clean ports: %v", err)
+		return err
 	}
-	for _, iface := range ifaces {
-		log.Printf("Info: interface %s has error: removing corresponding port", iface)
-		if err := ovsDriver.DeletePort(iface); err != nil {
-			// Don't return an error here, just log its occurrence.
-			// Something else may have removed the port already.
-			log.Printf("Error: %v\n", err)
+	if found {
+		return nil
+	}
+
+	log.Printf("Info: bridge %s is not found in OVS: creating it", bridgeName)
+	return ovsDriver.CreateBridge(bridgeName, ovsdb.BridgeOptions{
+		Uplink:       netconf.Uplink,
+		DatapathType: netconf.DatapathType,
+		FailMode:     netconf.FailMode,
+	})
+}
+
+// ResolveMTU returns the MTU to set on the container interface. With mtu set
+// to auto, it is the lowest MTU of the bridge uplinks minus the configured
+// encapsulation overhead.
+func ResolveMTU(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf) (int, error) {
+	if netconf.MTU != types.MTUAuto {
+		return int(netconf.MTU), nil
+	}
+
+	uplinkMTUs, err := ovsDriver.GetBridgeUplinkMTUs(ovsDriver.OvsBridgeName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get uplinks of bridge %s: %v", ovsDriver.OvsBridgeName, err)
+	}
+
+	// fall back to the kernel for the MTU not reported by vswitchd yet
+	for uplink, mtu := range uplinkMTUs {
+		if mtu != 0 {
+			continue
+		}
+		if link, err := netlink.LinkByName(uplink); err == nil {
+			uplinkMTUs[uplink] = link.Attrs().MTU
 		}
 	}
+
+	return autoMTU(ovsDriver.OvsBridgeName, uplinkMTUs, netconf.MTUOverhead)
+}
+
+func autoMTU(bridgeName string, uplinkMTUs map[string]int, overhead int) (int, error) {
+	mtu := 0
+	for _, uplinkMTU := range uplinkMTUs {
+		if uplinkMTU > 0 && (mtu == 0 || uplinkMTU < mtu) {
+			mtu = uplinkMTU
+		}
+	}
+	if mtu == 0 {
+		return 0, fmt.Errorf("mtu auto: no uplink with a known MTU found on bridge %s", bridgeName)
+	}
+
+	mtu -= overhead
+	if mtu < minMTU {
+		return 0, fmt.Errorf("mtu auto: MTU %d of bridge %s is lower than %d after subtracting overhead %d",
+			mtu+overhead, bridgeName, minMTU, overhead)
+	}
+	return mtu, nil
+}
+
+// CleanStalePorts removes the ports created for the given network whose
+// container attachment is not in the set of valid attachments (indexed by
+// container reference, see config.GetCRef). Ports created before the
+// container ID was recorded on the port don't tell their network, they are
+// removed once their container netns is gone. Port security flows of the
+// removed ports are removed as well when portSecurity is set.
+func CleanStalePorts(ovsDriver *ovsdb.OvsDriver, netName string, validAttachments map[string]bool, portSecurity bool) error {
+	ports, err := ovsDriver.FindNetworkPorts(netName)
+	if err != nil {
+		return fmt.Errorf("clean stale ports: %v", err)
+	}
+	for portName, externalIDs := range ports {
+		containerID := externalIDs["contContainerId"]
+		if containerID == "" {
+			continue
+		}
+		if validAttachments[config.GetCRef(containerID, externalIDs["contIface"])] {
+			continue
+		}
+		log.Printf("Info: port %s of container %s is stale: removing it", portName, containerID)
+		removeStalePort(ovsDriver, portName, portSecurity)
+	}
+
+	legacyPorts, err := ovsDriver.FindLegacyPorts()
+	if err != nil {
+		return fmt.Errorf("clean stale ports: %v", err)
+	}
+	for portName, externalIDs := range legacyPorts {
+		contNetns := externalIDs["contNetns"]
+		if contNetns == "" {
+			continue
+		}
+		if _, err := os.Stat(contNetns); !os.IsNotExist(err) {
+			continue
+		}
+		log.Printf("Info: port %s of removed netns %s is stale: removing it", portName, contNetns)
+		removeStalePort(ovsDriver, portName, portSecurity)
+	}
 	return nil
 }
 
+// removeStalePort removes a stale port, its port security flows first so
+// that they don't apply to the next port given its ofport, and the host side
+// of its veth pair. Removal is best-effort, errors are logged.
+func removeStalePort(ovsDriver *ovsdb.OvsDriver, portName string, portSecurity bool) {
+	bridgeName, err := ovsDriver.FindBridgeByInterface(portName)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		return
+	}
+	bridgeDriver := &ovsdb.OvsBridgeDriver{OvsDriver: *ovsDriver, OvsBridgeName: bridgeName}
+	if portSecurity {
+		if err := RemovePortSecurity(bridgeDriver, portName); err != nil {
+			log.Printf("Error: %v\n", err)
+		}
+	}
+	if err := bridgeDriver.DeletePort(portName); err != nil {
+		// Something else may have removed the port already.
+		log.Printf("Error: %v\n", err)
+		return
+	}
+	// remove the host side of the veth pair in case the container
+	// netns still exists, other link types (e.g. VF representors)
+	// must be kept.
+	if link, err := netlink.LinkByName(portName); err == nil {
+		if _, isVeth := link.(*netlink.Veth); isVeth {
+			if err := netlink.LinkDel(link); err != nil {
+				log.Printf("Failed best-effort cleanup of %s: %v", portName, err)
+			}
+		}
+	}
+}
+
 func RefetchIface(iface *current.Interface) error {
 	link, err := netlink.LinkByName(iface.Name)
 	if err != nil {
@@ -232,8 +715,8 @@ func RefetchIface(iface *current.Interface) error {
 	return nil
 }
 
-func AttachIfaceToBridge(ovsDriver *ovsdb.OvsBridgeDriver, hostIfaceName string, contIfaceName string, ofportRequest uint, vlanTag uint, trunks []uint, portType string, intfType string, contNetnsPath string, ovnPortName string, contPodUid string) error {
-	err := ovsDriver.CreatePort(hostIfaceName, contNetnsPath, contIfaceName, ovnPortName, ofportRequest, vlanTag, trunks, portType, intfType, contPodUid)
+func AttachIfaceToBridge(ovsDriver *ovsdb.OvsBridgeDriver, hostIfaceName string, contIfaceName string, ofportRequest uint, vlanTag uint, trunks []uint, portType string, qinq ovsdb.PortQinQ, qos ovsdb.PortQoS, intfType string, contNetnsPath string, ovnPortName string, ovnPortVer string, contPodUid string, contContainerID string, netName string, externalIDs map[string]string) error {
+	err := ovsDriver.CreatePort(hostIfaceName, contNetnsPath, contIfaceName, ovnPortName, ovnPortVer, ofportRequest, vlanTag, trunks, portType, qinq, qos, intfType, nil, contPodUid, contContainerID, netName, externalIDs)
 	if err != nil {
 		return err
 	}
@@ -250,12 +733,91 @@ func AttachIfaceToBridge(ovsDriver *ovsdb.OvsBridgeDriver, hostIfaceName string,
 	return nil
 }
 
+// GetPortExternalIDs returns the external_ids recorded by the port of an
+// attachment besides the ones identifying the container interface: the
+// cached state of the attachment and the pod it belongs to
+func GetPortExternalIDs(cache *types.CachedNetConf, envArgs *types.EnvArgs) map[string]string {
+	externalIDs := config.CachedNetConfToExternalIDs(cache)
+	if envArgs == nil {
+		return externalIDs
+	}
+	if envArgs.K8S_POD_NAMESPACE != "" {
+		externalIDs[podNamespaceExternalID] = string(envArgs.K8S_POD_NAMESPACE)
+	}
+	if envArgs.K8S_POD_NAME != "" {
+		externalIDs[podNameExternalID] = string(envArgs.K8S_POD_NAME)
+	}
+	return externalIDs
+}
+
+// RecordAttachmentAddresses records the MAC and IP addresses assigned to
+// the container interface on the OVS interface of the attachment
+func RecordAttachmentAddresses(ovsDriver *ovsdb.OvsBridgeDriver, hostIfaceName string, result *current.Result) error {
+	externalIDs := getAddressesExternalIDs(result)
+	if len(externalIDs) == 0 {
+		return nil
+	}
+	if err := ovsDriver.AddInterfaceExternalIDs(hostIfaceName, externalIDs); err != nil {
+		return fmt.Errorf("failed to record the addresses of %s: %v", hostIfaceName, err)
+	}
+	return nil
+}
+
+func getAddressesExternalIDs(result *current.Result) map[string]string {
+	externalIDs := map[string]string{}
+	contIfIndex := -1
+	for i, intf := range result.Interfaces {
+		if intf.Sandbox != "" {
+			contIfIndex = i
+			break
+		}
+	}
+	if contIfIndex >= 0 && result.Interfaces[contIfIndex].Mac != "" {
+		externalIDs[attachedMacExternalID] = result.Interfaces[contIfIndex].Mac
+	}
+
+	var addresses []string
+	for _, ipc := range result.IPs {
+		if ipc.Interface == nil || *ipc.Interface == contIfIndex {
+			addresses = append(addresses, ipc.Address.String())
+		}
+	}
+	if len(addresses) > 0 {
+		externalIDs[ipAddressesExternalID] = strings.Join(addresses, ",")
+	}
+	return externalIDs
+}
+
+// SaveDeviceInfo publishes the device information of an attachment, along
+// with the ovs port it is connected to. deviceInfo is nil for attachments
+// without a device.
+func SaveDeviceInfo(ovsDriver *ovsdb.OvsBridgeDriver, devInfoPath, portName string, deviceInfo *netv1.DeviceInfo) error {
+	// the ofport is left out until vswitchd assigns it
+	ofport, err := ovsDriver.GetOFPortNumber(portName)
+	if err != nil {
+		return fmt.Errorf("failed to get ofport of %s: %v", portName, err)
+	}
+
+	ovsDeviceInfo := &deviceinfo.OvsDeviceInfo{
+		DeviceInfo: netv1.DeviceInfo{Version: netv1.DeviceInfoVersion},
+		Ovs: &deviceinfo.OvsPort{
+			Bridge: ovsDriver.OvsBridgeName,
+			Port:   portName,
+			Ofport: ofport,
+		},
+	}
+	if deviceInfo != nil {
+		ovsDeviceInfo.DeviceInfo = *deviceInfo
+	}
+	return deviceinfo.SaveDeviceInfo(devInfoPath, ovsDeviceInfo)
+}
+
 func RemoveOvsPort(ovsDriver *ovsdb.OvsBridgeDriver, portName string) error {
 	return ovsDriver.DeletePort(portName)
 }
 
-func CleanupOvsPortBestEffort(ovsDriver *ovsdb.OvsBridgeDriver, ifaceName string, contNetns string) (string, bool, error) {
-	portName, portFound, err := ovsDriver.GetOvsPortForContIface(ifaceName, contNetns)
+func CleanupOvsPortBestEffort(ovsDriver *ovsdb.OvsBridgeDriver, hostIfaceName string, ifaceName string, contNetns string) (string, bool, error) {
+	portName, portFound, err := ovsDriver.GetOvsPortForContIface(hostIfaceName, ifaceName, contNetns)
 
 	if err != nil {
 		return "", false, fmt.Errorf("Failed to obtain OVS port for given connection: %v", err)
@@ -269,6 +831,31 @@ func CleanupOvsPortBestEffort(ovsDriver *ovsdb.OvsBridgeDriver, ifaceName string
 	return portName, portFound, nil
 }
 
+// CheckHostIfaceName fails when the name of the host interface of an
+// attachment, if not random, is used by a host link or an OVS port already
+func CheckHostIfaceName(ovsDriver *ovsdb.OvsBridgeDriver, hostIfaceName string) error {
+	if hostIfaceName == "" {
+		return nil
+	}
+
+	_, err := netlink.LinkByName(hostIfaceName)
+	if err == nil {
+		return fmt.Errorf("host interface name %s is used by another link, check the hostIfaceNaming config parameter", hostIfaceName)
+	}
+	if _, ok := err.(netlink.LinkNotFoundError); !ok {
+		return fmt.Errorf("failed to look up host interface %s: %v", hostIfaceName, err)
+	}
+
+	found, err := ovsDriver.IsPortPresent(hostIfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to look up port %s: %v", hostIfaceName, err)
+	}
+	if found {
+		return fmt.Errorf("host interface name %s is used by another port, check the hostIfaceNaming config parameter", hostIfaceName)
+	}
+	return nil
+}
+
 // IPAddrToHWAddr takes the four octets of IPv4 address (aa.bb.cc.dd, for example) and uses them in creating
 // a MAC address (0A:58:AA:BB:CC:DD).  For IPv6, create a hash from the IPv6 string and use that for MAC Address.
 // Assumption: the caller will ensure that an empty net.IP{} will NOT be passed.
@@ -286,22 +873,114 @@ func IPAddrToHWAddr(ip net.IP) net.HardwareAddr {
 }
 
 func waitLinkUp(ovsDriver *ovsdb.OvsBridgeDriver, ofPortName string, retryCount, interval int) error {
-	checkInterval := time.Duration(interval) * time.Millisecond
-	for i := 1; i <= retryCount; i++ {
-		portState, err := ovsDriver.GetOFPortOpState(ofPortName)
-		if err != nil {
-			log.Printf("error in retrieving port %s state: %v", ofPortName, err)
-		} else {
-			if portState == "up" {
-				break
-			}
+	// the retries and interval only bound the time to wait for, the state is
+	// notified by the interface monitor
+	timeout := time.Duration(retryCount*interval) * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if _, err := ovsDriver.WaitInterfaceUp(ctx, ofPortName); err != nil {
+		return fmt.Errorf("The OF port %s state is not up, try increasing number of retries/interval config parameter: %v", ofPortName, err)
+	}
+	return nil
+}
+
+func waitOFPortNumber(ovsDriver *ovsdb.OvsDriver, ofPortName string, retryCount, interval int) (int, error) {
+	// the retries and interval only bound the time to wait for, the ofport
+	// is notified by the interface monitor
+	timeout := time.Duration(retryCount*interval) * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ofport, err := ovsDriver.WaitOFPort(ctx, ofPortName)
+	if err != nil {
+		return 0, fmt.Errorf("The OF port %s number is not assigned, try increasing number of retries/interval config parameter: %v", ofPortName, err)
+	}
+	return ofport, nil
+}
+
+// getContainerAddresses returns the MAC and IP addresses of the container
+// interface found in the result
+func getContainerAddresses(result *current.Result) (string, []net.IP, error) {
+	contIfIndex := -1
+	for i, intf := range result.Interfaces {
+		if intf.Sandbox != "" {
+			contIfIndex = i
+			break
 		}
-		if i == retryCount {
-			return fmt.Errorf("The OF port %s state is not up, try increasing number of retries/interval config parameter", ofPortName)
+	}
+	if contIfIndex < 0 || result.Interfaces[contIfIndex].Mac == "" {
+		return "", nil, fmt.Errorf("failed to find container interface MAC address in result")
+	}
+
+	var ips []net.IP
+	for _, ipc := range result.IPs {
+		if ipc.Interface == nil || *ipc.Interface == contIfIndex {
+			ips = append(ips, ipc.Address.IP)
 		}
-		time.Sleep(checkInterval)
 	}
-	return nil
+	return result.Interfaces[contIfIndex].Mac, ips, nil
+}
+
+// SetupPortSecurity installs flows on the bridge allowing only traffic with
+// the container interface MAC and IP addresses from the result to enter the
+// bridge through the host interface port.
+func SetupPortSecurity(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, hostIfaceName string, result *current.Result) error {
+	mac, ips, err := getContainerAddresses(result)
+	if err != nil {
+		return err
+	}
+	target, err := portsecurity.Target(ovsDriver.SocketFile(), ovsDriver.OvsBridgeName)
+	if err != nil {
+		return err
+	}
+	ofport, err := waitOFPortNumber(&ovsDriver.OvsDriver, hostIfaceName, netconf.LinkStateCheckRetries, netconf.LinkStateCheckInterval)
+	if err != nil {
+		return err
+	}
+	return portsecurity.AddFlows(target, hostIfaceName, ofport, mac, ips)
+}
+
+// RemovePortSecurity removes the port security flows of the port from the
+// bridge, it is a no-op when no such flows exist.
+func RemovePortSecurity(ovsDriver *ovsdb.OvsBridgeDriver, portName string) error {
+	target, err := portsecurity.Target(ovsDriver.SocketFile(), ovsDriver.OvsBridgeName)
+	if err != nil {
+		return err
+	}
+	return portsecurity.DelFlows(target, portName)
+}
+
+// CleanupPortSecurity removes the port security flows of the port connected
+// to the given container interface. It must be called before the port is
+// removed, since the port can't be found afterwards.
+func CleanupPortSecurity(ovsDriver *ovsdb.OvsBridgeDriver, hostIfaceName string, ifaceName string, contNetns string) error {
+	portName, portFound, err := ovsDriver.GetOvsPortForContIface(hostIfaceName, ifaceName, contNetns)
+	if err != nil {
+		return fmt.Errorf("Failed to obtain OVS port for given connection: %v", err)
+	}
+	if !portFound {
+		return nil
+	}
+	return RemovePortSecurity(ovsDriver, portName)
+}
+
+// ValidatePortSecurity checks that the port security flows installed for the
+// host interface match the addresses of the container interface in the result.
+func ValidatePortSecurity(ovsDriver *ovsdb.OvsDriver, netconf *types.NetConf, hostIfname string, result *current.Result) error {
+	mac, ips, err := getContainerAddresses(result)
+	if err != nil {
+		return err
+	}
+	target, err := portsecurity.Target(ovsDriver.SocketFile(), netconf.BrName)
+	if err != nil {
+		return err
+	}
+	ofport, err := ovsDriver.GetOFPortNumber(hostIfname)
+	if err != nil {
+		return fmt.Errorf("Error: Failed to retrieve port %s number: %v", hostIfname, err)
+	}
+	return portsecurity.CheckFlows(target, hostIfname, ofport, mac, ips)
 }
 
 func assignMacToLink(link netlink.Link, mac net.HardwareAddr, name string) error {
@@ -435,7 +1114,9 @@ func ValidateInterface(intf current.Interface, isHost bool, hwOffload bool) erro
 	}
 	if !hwOffload {
 		_, isVeth := link.(*netlink.Veth)
-		if !isVeth {
+		// containers attached through an OVS internal port
+		isInternal := !isHost && link.Type() == "openvswitch"
+		if !isVeth && !isInternal {
 			return fmt.Errorf("Error: %s interface %s not of type veth/p2p", iftype, link.Attrs().Name)
 		}
 	}
@@ -447,19 +1128,19 @@ func ValidateInterface(intf current.Interface, isHost bool, hwOffload bool) erro
 	return nil
 }
 
-func ValidateOvs(args *skel.CmdArgs, netconf *types.NetConf, hostIfname string) error {
-	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(netconf.BrName, netconf.SocketFile)
+func ValidateOvs(ovsDriver *ovsdb.OvsDriver, args *skel.CmdArgs, netconf *types.NetConf, hostIfname string) error {
+	found, err := ovsDriver.IsBridgePresent(netconf.BrName)
 	if err != nil {
 		return err
 	}
+	if !found {
+		return fmt.Errorf("Error: bridge %s is not found in OVS", netconf.BrName)
+	}
 
-	found, err := ovsBridgeDriver.IsBridgePresent(netconf.BrName)
+	ovsBridgeDriver, err := ovsDriver.BridgeDriver(netconf.BrName)
 	if err != nil {
 		return err
 	}
-	if !found {
-		return fmt.Errorf("Error: bridge %s is not found in OVS", netconf.BrName)
-	}
 
 	hasError, err := ovsBridgeDriver.InterfaceHasError(hostIfname)
 	if err != nil {
@@ -469,11 +1150,25 @@ func ValidateOvs(args *skel.CmdArgs, netconf *types.NetConf, hostIfname string)
 		return fmt.Errorf("Error: interface %s is in error state", hostIfname)
 	}
 
+	// there is no host netdev with internal and vhost-user ports
+	if netconf.MTU != 0 && (netconf.PortMode == "" || netconf.PortMode == types.PortModeVeth) {
+		if err := validateOvsMTU(ovsBridgeDriver, netconf, hostIfname); err != nil {
+			return err
+		}
+	}
+
 	vlanMode, tag, trunk, err := ovsBridgeDriver.GetOFPortVlanState(hostIfname)
 	if err != nil {
 		return fmt.Errorf("Error: Failed to retrieve port %s state: %v", hostIfname, err)
 	}
 
+	if netconf.VlanMode != "" {
+		if err := validateOvsVlanMode(ovsBridgeDriver, netconf, hostIfname, vlanMode, tag, trunk); err != nil {
+			return err
+		}
+		return validateOvsQoS(ovsBridgeDriver, netconf, hostIfname)
+	}
+
 	// check vlan tag
 	if netconf.VlanTag == nil {
 		if tag != nil {
@@ -515,6 +1210,82 @@ func ValidateOvs(args *skel.CmdArgs, netconf *types.NetConf, hostIfname string)
 		}
 	}
 
+	return validateOvsQoS(ovsBridgeDriver, netconf, hostIfname)
+}
+
+// validateOvsMTU checks the MTU of the host interface against the resolved one
+func validateOvsMTU(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, hostIfname string) error {
+	mtu, err := ResolveMTU(ovsDriver, netconf)
+	if err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(hostIfname)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %s: %v", hostIfname, err)
+	}
+	if link.Attrs().MTU != mtu {
+		return fmt.Errorf("mtu mismatch. host=%d,expected=%d", link.Attrs().MTU, mtu)
+	}
+	return nil
+}
+
+// validateOvsQoS checks the bandwidth limits of the port
+func validateOvsQoS(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, hostIfname string) error {
+	qos, err := ovsDriver.GetOFPortQoSState(hostIfname)
+	if err != nil {
+		return fmt.Errorf("Error: Failed to retrieve port %s qos: %v", hostIfname, err)
+	}
+	if netconfQoS := GetPortQoS(netconf); qos != netconfQoS {
+		return fmt.Errorf("qos mismatch. ovs=%+v,netconf=%+v", qos, netconfQoS)
+	}
+
+	return nil
+}
+
+// validateOvsVlanMode checks the VLAN configuration of a port created with
+// an explicit vlanMode
+func validateOvsVlanMode(ovsDriver *ovsdb.OvsBridgeDriver, netconf *types.NetConf, hostIfname, vlanMode string, tag *uint, trunk []uint) error {
+	portCfg, err := ParseOvsPortConfig(netconf)
+	if err != nil {
+		return err
+	}
+
+	if vlanMode != portCfg.Type {
+		return fmt.Errorf("vlan mode mismatch. expected=%s,real=%s", portCfg.Type, vlanMode)
+	}
+
+	if portCfg.Type == portTypeTrunk {
+		if tag != nil {
+			return fmt.Errorf("vlan tag mismatch. ovs=%d,netconf=nil", *tag)
+		}
+	} else if tag == nil || *tag != portCfg.VlanTag {
+		ovsTag := "nil"
+		if tag != nil {
+			ovsTag = fmt.Sprintf("%d", *tag)
+		}
+		return fmt.Errorf("vlan tag mismatch. ovs=%s,netconf=%d", ovsTag, portCfg.VlanTag)
+	}
+
+	if fmt.Sprint(trunk) != fmt.Sprint(portCfg.Trunks) {
+		return fmt.Errorf("trunk mismatch. ovs=%v,netconf=%v", trunk, portCfg.Trunks)
+	}
+
+	if portCfg.Type != portTypeDot1qTunnel {
+		return nil
+	}
+
+	qinq, err := ovsDriver.GetOFPortQinQState(hostIfname)
+	if err != nil {
+		return fmt.Errorf("Error: Failed to retrieve port %s qinq state: %v", hostIfname, err)
+	}
+	if fmt.Sprint(qinq.Cvlans) != fmt.Sprint(portCfg.QinQ.Cvlans) {
+		return fmt.Errorf("cvlans mismatch. ovs=%v,netconf=%v", qinq.Cvlans, portCfg.QinQ.Cvlans)
+	}
+	if qinq.Ethtype != portCfg.QinQ.Ethtype {
+		return fmt.Errorf("qinq ethtype mismatch. ovs=%s,netconf=%s", qinq.Ethtype, portCfg.QinQ.Ethtype)
+	}
+
 	return nil
 }
 
@@ -542,11 +1313,17 @@ func validateCache(cache *types.CachedNetConf, netconf *types.NetConf) error {
 	return nil
 }
 
-func CacheLoadAndCheck(args *skel.CmdArgs, netconf *types.NetConf) (*types.CachedNetConf, error) {
+// CacheLoadAndCheck loads the cached state of the attachment, rebuilt from
+// its port when the cache is lost, and checks that it matches netconf
+func CacheLoadAndCheck(ovsDriver *ovsdb.OvsDriver, args *skel.CmdArgs, netconf *types.NetConf) (*types.CachedNetConf, error) {
 	cRef := config.GetCRef(args.ContainerID, args.IfName)
 	cache, err := config.LoadConfFromCache(cRef)
 	if err != nil {
-		return nil, err
+		log.Printf("Warning: failed to load cached netconf, recovering it from ovsdb: %v", err)
+		cache, err = RecoverCachedNetConf(ovsDriver, args, netconf)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	err = validateCache(cache, netconf)
@@ -557,6 +1334,19 @@ func CacheLoadAndCheck(args *skel.CmdArgs, netconf *types.NetConf) (*types.Cache
 	return cache, nil
 }
 
+// RecoverCachedNetConf rebuilds the cached state of an attachment from
+// netconf and the external_ids of the port created for it
+func RecoverCachedNetConf(ovsDriver *ovsdb.OvsDriver, args *skel.CmdArgs, netconf *types.NetConf) (*types.CachedNetConf, error) {
+	port, err := ovsDriver.FindContainerPort(args.ContainerID, args.IfName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the port of %s: %v", args.IfName, err)
+	}
+	if port == nil {
+		return nil, fmt.Errorf("no port found for %s of container %s", args.IfName, args.ContainerID)
+	}
+	return config.CachedNetConfFromExternalIDs(netconf, port.Bridge, port.ExternalIDs)
+}
+
 // ParsePrevResult parses the previous CNI result from netconf
 func ParsePrevResult(netconf *types.NetConf) (*current.Result, error) {
 	if netconf.NetConf.RawPrevResult == nil {
@@ -628,14 +1418,20 @@ func ValidateNetnsAttachment(args *skel.CmdArgs, result *current.Result, contInt
 //
 // Bridge name from netconf must be updated with the expected one before
 // calling the function.
-func ValidateAttachment(args *skel.CmdArgs, netconf *types.NetConf, cache *types.CachedNetConf) error {
-	ovsHWOffloadEnable := IsOvsHardwareOffloadEnabled(netconf.DeviceID)
-
+func ValidateAttachment(ovsDriver *ovsdb.OvsDriver, args *skel.CmdArgs, netconf *types.NetConf, cache *types.CachedNetConf) error {
 	result, err := ParsePrevResult(netconf)
 	if err != nil {
 		return err
 	}
 
+	return ValidateResult(ovsDriver, args, netconf, result)
+}
+
+// ValidateResult checks that the attachment described by the result of ADD
+// is in place, see ValidateAttachment.
+func ValidateResult(ovsDriver *ovsdb.OvsDriver, args *skel.CmdArgs, netconf *types.NetConf, result *current.Result) error {
+	ovsHWOffloadEnable := IsOvsHardwareOffloadEnabled(netconf.DeviceID)
+
 	hostIntf, contIntf, err := ExtractInterfaces(args, result, ovsHWOffloadEnable)
 	if err != nil {
 		return err
@@ -647,5 +1443,12 @@ func ValidateAttachment(args *skel.CmdArgs, netconf *types.NetConf, cache *types
 	}
 
 	// ovs specific check
-	return ValidateOvs(args, netconf, hostIntf.Name)
+	if err := ValidateOvs(ovsDriver, args, netconf, hostIntf.Name); err != nil {
+		return err
+	}
+
+	if netconf.PortSecurity {
+		return ValidatePortSecurity(ovsDriver, netconf, hostIntf.Name, result)
+	}
+	return nil
 }
@@ -15,10 +15,13 @@ package common
 import (
 	"encoding/json"
 	"errors"
+	"net"
 
+	current "github.com/containernetworking/cni/pkg/types/100"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
@@ -84,4 +87,319 @@ var _ = Describe("", func() {
 			testSplitVlanIds(trunks, []uint{}, errors.New("incorrect trunk id parameter"), false)
 		})
 	})
+
+	Context("port QoS", func() {
+		It("should use the netconf limits", func() {
+			netconf := &types.NetConf{
+				IngressPolicingRate:  1000,
+				IngressPolicingBurst: 100,
+				EgressShapingRate:    2000000,
+				EgressShapingBurst:   200000,
+			}
+			Expect(GetPortQoS(netconf)).To(Equal(ovsdb.PortQoS{
+				IngressPolicingRate:  1000,
+				IngressPolicingBurst: 100,
+				EgressRate:           2000000,
+				EgressBurst:          200000,
+			}))
+		})
+		It("should prefer the bandwidth runtime capability", func() {
+			netconf := &types.NetConf{
+				IngressPolicingRate: 1000,
+				EgressShapingRate:   2000000,
+				RuntimeConfig: &types.RuntimeConfig{
+					Bandwidth: &types.BandwidthEntry{
+						IngressRate:  3000000,
+						IngressBurst: 300000,
+						EgressRate:   4000000,
+						EgressBurst:  400000,
+					},
+				},
+			}
+			Expect(GetPortQoS(netconf)).To(Equal(ovsdb.PortQoS{
+				IngressPolicingRate:  4000,
+				IngressPolicingBurst: 400,
+				EgressRate:           3000000,
+				EgressBurst:          300000,
+			}))
+		})
+		It("should drop bursts without a rate", func() {
+			netconf := &types.NetConf{
+				IngressPolicingBurst: 100,
+				EgressShapingBurst:   200000,
+			}
+			Expect(GetPortQoS(netconf)).To(Equal(ovsdb.PortQoS{}))
+		})
+	})
+
+	Context("port vlanMode", func() {
+		parse := func(conf string) (*OvsPortConfig, error) {
+			netconf := &types.NetConf{}
+			Expect(json.Unmarshal([]byte(conf), netconf)).To(Succeed())
+			return ParseOvsPortConfig(netconf)
+		}
+		It("should set native VLAN and trunks for native-tagged", func() {
+			portCfg, err := parse(`{"vlanMode": "native-tagged", "vlan": 100, "trunk": [{"id": 200}, {"id": 300}]}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(portCfg.Type).To(Equal("native-tagged"))
+			Expect(portCfg.VlanTag).To(Equal(uint(100)))
+			Expect(portCfg.Trunks).To(Equal([]uint{200, 300}))
+		})
+		It("should set service VLAN, cvlans and ethtype for dot1q-tunnel", func() {
+			portCfg, err := parse(`{"vlanMode": "dot1q-tunnel", "vlan": 100, "cvlans": [{"minID": 10, "maxID": 12}], "qinqEthtype": "802.1q"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(portCfg.Type).To(Equal("dot1q-tunnel"))
+			Expect(portCfg.VlanTag).To(Equal(uint(100)))
+			Expect(portCfg.QinQ).To(Equal(ovsdb.PortQinQ{Cvlans: []uint{10, 11, 12}, Ethtype: "802.1q"}))
+		})
+		It("should require vlan for native modes", func() {
+			_, err := parse(`{"vlanMode": "native-untagged", "trunk": [{"id": 200}]}`)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should reject cvlans outside of dot1q-tunnel", func() {
+			_, err := parse(`{"vlanMode": "native-untagged", "vlan": 100, "cvlans": [{"id": 10}]}`)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should reject unknown modes and ethtypes", func() {
+			_, err := parse(`{"vlanMode": "bogus", "vlan": 100}`)
+			Expect(err).To(HaveOccurred())
+			_, err = parse(`{"vlanMode": "dot1q-tunnel", "vlan": 100, "qinqEthtype": "0x88a8"}`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("mtu auto", func() {
+		It("should parse auto along with numbers", func() {
+			netconf := &types.NetConf{}
+			Expect(json.Unmarshal([]byte(`{"mtu": "auto"}`), netconf)).To(Succeed())
+			Expect(netconf.MTU).To(Equal(types.MTUAuto))
+			Expect(json.Unmarshal([]byte(`{"mtu": 9000}`), netconf)).To(Succeed())
+			Expect(netconf.MTU).To(Equal(types.MTU(9000)))
+			Expect(json.Unmarshal([]byte(`{"mtu": "jumbo"}`), netconf)).NotTo(Succeed())
+		})
+		It("should use the lowest uplink MTU minus the overhead", func() {
+			mtu, err := autoMTU("br1", map[string]int{"eth1": 9000, "eth2": 1600, "eth3": 0}, 50)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mtu).To(Equal(1550))
+		})
+		It("should fail without uplinks", func() {
+			_, err := autoMTU("br1", map[string]int{}, 0)
+			Expect(err).To(HaveOccurred())
+			_, err = autoMTU("br1", map[string]int{"eth1": 0}, 0)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should fail when the overhead leaves a too low MTU", func() {
+			_, err := autoMTU("br1", map[string]int{"eth1": 100}, 50)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("runtime config mac", func() {
+		It("should take precedence over CNI_ARGS and args.cni", func() {
+			netconf := &types.NetConf{}
+			Expect(json.Unmarshal([]byte(`{"runtimeConfig": {"mac": "0a:58:0a:00:00:01"}, "args": {"cni": {"mac": "0a:58:0a:00:00:02"}}}`), netconf)).To(Succeed())
+			mac := "0a:58:0a:00:00:03"
+			ApplyConfArgsFallback(netconf, &mac, nil)
+			ApplyRuntimeConfigMac(netconf, &mac)
+			Expect(mac).To(Equal("0a:58:0a:00:00:01"))
+		})
+		It("should keep the MAC from the args without runtime config", func() {
+			netconf := &types.NetConf{}
+			Expect(json.Unmarshal([]byte(`{"args": {"cni": {"mac": "0a:58:0a:00:00:02"}}}`), netconf)).To(Succeed())
+			mac := ""
+			ApplyConfArgsFallback(netconf, &mac, nil)
+			ApplyRuntimeConfigMac(netconf, &mac)
+			Expect(mac).To(Equal("0a:58:0a:00:00:02"))
+		})
+	})
+	Context("vlan args", func() {
+		apply := func(conf string, envArgs *types.EnvArgs) (*types.NetConf, *OvsPortConfig, error) {
+			netconf := &types.NetConf{}
+			Expect(json.Unmarshal([]byte(conf), netconf)).To(Succeed())
+			if err := ApplyVlanArgs(netconf, envArgs); err != nil {
+				return nil, nil, err
+			}
+			portCfg, err := ParseOvsPortConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			return netconf, portCfg, nil
+		}
+		It("should keep the configuration without args", func() {
+			_, portCfg, err := apply(`{"vlan": 100, "args": {"cni": {"mac": "0a:58:0a:00:00:02"}}}`, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(portCfg.Type).To(Equal("access"))
+			Expect(portCfg.VlanTag).To(Equal(uint(100)))
+		})
+		It("should override the vlan from args.cni", func() {
+			_, portCfg, err := apply(`{"vlan": 100, "allowedVlans": [{"minID": 200, "maxID": 299}], "args": {"cni": {"vlan": 200}}}`, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(portCfg.Type).To(Equal("access"))
+			Expect(portCfg.VlanTag).To(Equal(uint(200)))
+		})
+		It("should replace the trunks of the configuration when vlan is requested", func() {
+			netconf, _, err := apply(`{"trunk": [{"id": 100}], "allowedVlans": [{"id": 200}], "args": {"cni": {"VLAN": "200"}}}`, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.Trunk).To(BeEmpty())
+			Expect(*netconf.VlanTag).To(Equal(uint(200)))
+		})
+		It("should override the trunk and vlanMode from args.cni", func() {
+			_, portCfg, err := apply(`{"vlan": 100, "allowedVlans": [{"minID": 10, "maxID": 30}], "args": {"cni": {"vlan": 10, "trunk": [{"id": 20}, {"minID": 25, "maxID": 26}], "vlanMode": "native-untagged"}}}`, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(portCfg.Type).To(Equal("native-untagged"))
+			Expect(portCfg.VlanTag).To(Equal(uint(10)))
+			Expect(portCfg.Trunks).To(Equal([]uint{20, 25, 26}))
+		})
+		It("should prefer CNI_ARGS over args.cni", func() {
+			envArgs := &types.EnvArgs{}
+			Expect(envArgs.Trunk.UnmarshalText([]byte("20,25-26"))).To(Succeed())
+			_, portCfg, err := apply(`{"allowedVlans": [{"minID": 10, "maxID": 30}], "args": {"cni": {"trunk": "10"}}}`, envArgs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(portCfg.Type).To(Equal("trunk"))
+			Expect(portCfg.Trunks).To(Equal([]uint{20, 25, 26}))
+		})
+		It("should reject requests without allowedVlans", func() {
+			_, _, err := apply(`{"vlan": 100, "args": {"cni": {"vlan": 200}}}`, nil)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should reject VLANs which aren't allowed", func() {
+			_, _, err := apply(`{"allowedVlans": [{"minID": 200, "maxID": 299}], "args": {"cni": {"vlan": 300}}}`, nil)
+			Expect(err).To(MatchError(ContainSubstring("vlan 300 isn't allowed")))
+			_, _, err = apply(`{"allowedVlans": [{"minID": 200, "maxID": 299}], "args": {"cni": {"trunk": "200-300"}}}`, nil)
+			Expect(err).To(MatchError(ContainSubstring("trunk vlan 300 isn't allowed")))
+		})
+		It("should not check the VLANs of the configuration", func() {
+			_, portCfg, err := apply(`{"vlan": 100, "trunk": [{"id": 300}], "allowedVlans": [{"id": 200}], "args": {"cni": {"vlanMode": "native-untagged"}}}`, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(portCfg.Type).To(Equal("native-untagged"))
+			Expect(portCfg.VlanTag).To(Equal(uint(100)))
+			Expect(portCfg.Trunks).To(Equal([]uint{300}))
+		})
+		It("should reject a trunk port carrying all VLANs", func() {
+			_, _, err := apply(`{"vlan": 200, "allowedVlans": [{"id": 200}], "args": {"cni": {"vlanMode": "trunk"}}}`, nil)
+			Expect(err).To(HaveOccurred())
+			_, _, err = apply(`{"vlan": 200, "allowedVlans": [{"id": 200}], "args": {"cni": {"vlanMode": "native-tagged"}}}`, nil)
+			Expect(err).To(MatchError(ContainSubstring("carrying all VLANs")))
+		})
+		It("should reject malformed requests", func() {
+			_, _, err := apply(`{"allowedVlans": [{"id": 200}], "args": {"cni": {"vlan": "two hundred"}}}`, nil)
+			Expect(err).To(HaveOccurred())
+			_, _, err = apply(`{"allowedVlans": [{"id": 200}], "args": {"cni": {"vlan": 5000}}}`, nil)
+			Expect(err).To(HaveOccurred())
+			_, _, err = apply(`{"allowedVlans": [{"id": 200}], "args": {"cni": {"trunk": "200-"}}}`, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("wait for ovn-installed", func() {
+		resolve := func(conf, ovnPort string) *types.NetConf {
+			netconf := &types.NetConf{}
+			Expect(json.Unmarshal([]byte(conf), netconf)).To(Succeed())
+			ResolveWaitForOvnInstalled(netconf, ovnPort)
+			return netconf
+		}
+		It("should wait by default when br-int is selected for the ovnPort", func() {
+			netconf := resolve(`{}`, "lsp1")
+			Expect(*netconf.WaitForOvnInstalled).To(BeTrue())
+			Expect(GetOvnPortVersion(netconf, "pod-uid")).To(Equal("pod-uid"))
+		})
+		It("should not wait by default when the bridge is set", func() {
+			netconf := resolve(`{"bridge": "br1"}`, "lsp1")
+			Expect(*netconf.WaitForOvnInstalled).To(BeFalse())
+			Expect(GetOvnPortVersion(netconf, "pod-uid")).To(BeEmpty())
+		})
+		It("should follow the configuration", func() {
+			Expect(*resolve(`{"bridge": "br1", "waitForOvnInstalled": true}`, "lsp1").WaitForOvnInstalled).To(BeTrue())
+			Expect(*resolve(`{"waitForOvnInstalled": false}`, "lsp1").WaitForOvnInstalled).To(BeFalse())
+		})
+		It("should not wait without ovnPort", func() {
+			Expect(*resolve(`{"bridge": "br1", "waitForOvnInstalled": true}`, "").WaitForOvnInstalled).To(BeFalse())
+		})
+	})
+	Context("port security with ovnPort", func() {
+		It("should reject port security for an ovnPort", func() {
+			netconf := &types.NetConf{PortSecurity: true}
+			Expect(CheckPortSecurityOvnPort(netconf, "lsp1")).NotTo(Succeed())
+			Expect(CheckPortSecurityOvnPort(netconf, "")).To(Succeed())
+			Expect(CheckPortSecurityOvnPort(&types.NetConf{}, "lsp1")).To(Succeed())
+		})
+	})
+	Context("attachment external_ids", func() {
+		It("should record the pod next to the cached state", func() {
+			envArgs := &types.EnvArgs{}
+			Expect(envArgs.K8S_POD_NAMESPACE.UnmarshalText([]byte("ns1"))).To(Succeed())
+			Expect(envArgs.K8S_POD_NAME.UnmarshalText([]byte("pod1"))).To(Succeed())
+			cache := &types.CachedNetConf{Netconf: &types.NetConf{BrName: "br1"}}
+			externalIDs := GetPortExternalIDs(cache, envArgs)
+			Expect(externalIDs).To(HaveKeyWithValue("contPodNamespace", "ns1"))
+			Expect(externalIDs).To(HaveKeyWithValue("contPodName", "pod1"))
+			Expect(externalIDs).To(HaveKeyWithValue("bridge", "br1"))
+		})
+		It("should not record the pod without args", func() {
+			cache := &types.CachedNetConf{Netconf: &types.NetConf{BrName: "br1"}}
+			externalIDs := GetPortExternalIDs(cache, nil)
+			Expect(externalIDs).NotTo(HaveKey("contPodNamespace"))
+			Expect(externalIDs).NotTo(HaveKey("contPodName"))
+		})
+		It("should record the addresses of the container interface", func() {
+			_, ipv4, _ := net.ParseCIDR("10.1.0.2/24")
+			ipv4.IP = net.ParseIP("10.1.0.2")
+			_, ipv6, _ := net.ParseCIDR("fd00::2/64")
+			ipv6.IP = net.ParseIP("fd00::2")
+			result := &current.Result{
+				Interfaces: []*current.Interface{
+					{Name: "veth1", Mac: "0a:58:0a:01:00:01"},
+					{Name: "eth0", Mac: "0a:58:0a:01:00:02", Sandbox: "/var/run/netns/ns1"},
+				},
+				IPs: []*current.IPConfig{
+					{Interface: current.Int(1), Address: *ipv4},
+					{Interface: current.Int(1), Address: *ipv6},
+				},
+			}
+			Expect(getAddressesExternalIDs(result)).To(Equal(map[string]string{
+				"attached-mac": "0a:58:0a:01:00:02",
+				"ip_addresses": "10.1.0.2/24,fd00::2/64",
+			}))
+		})
+		It("should not record missing addresses", func() {
+			result := &current.Result{
+				Interfaces: []*current.Interface{{Name: "veth1"}, {Name: "eth0", Sandbox: "/var/run/netns/ns1"}},
+			}
+			Expect(getAddressesExternalIDs(result)).To(BeEmpty())
+		})
+	})
+	Context("rollback", func() {
+		It("should undo the steps in reverse order", func() {
+			var undone []string
+			rollback := &Rollback{}
+			for _, step := range []string{"cache", "link", "port"} {
+				step := step
+				rollback.Push(step, func() error {
+					undone = append(undone, step)
+					return nil
+				})
+			}
+			rollback.Run()
+			Expect(undone).To(Equal([]string{"port", "link", "cache"}))
+		})
+		It("should undo the remaining steps when one fails", func() {
+			var undone []string
+			rollback := &Rollback{}
+			rollback.Push("cache", func() error {
+				undone = append(undone, "cache")
+				return nil
+			})
+			rollback.Push("link", func() error {
+				return errors.New("link not found")
+			})
+			rollback.Run()
+			Expect(undone).To(Equal([]string{"cache"}))
+		})
+		It("should undo the steps only once", func() {
+			count := 0
+			rollback := &Rollback{}
+			rollback.Push("cache", func() error {
+				count++
+				return nil
+			})
+			rollback.Run()
+			rollback.Run()
+			Expect(count).To(Equal(1))
+		})
+	})
 })
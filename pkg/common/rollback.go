@@ -0,0 +1,46 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"log"
+)
+
+type rollbackStep struct {
+	description string
+	undo        func() error
+}
+
+// Rollback records how to undo each completed step of an ADD, so that a
+// failed ADD leaves the node as it was before it was called.
+type Rollback struct {
+	steps []rollbackStep
+}
+
+// Push records the undo of a step that has just been completed. The
+// description names the step in the logs when undoing it fails.
+func (r *Rollback) Push(description string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{description: description, undo: undo})
+}
+
+// Run undoes the recorded steps, last one first. Undoing is best-effort, a
+// failed step is logged and doesn't prevent the remaining ones from running.
+func (r *Rollback) Run() {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		if err := step.undo(); err != nil {
+			log.Printf("Failed best-effort rollback of %s: %v", step.description, err)
+		}
+	}
+	r.steps = nil
+}
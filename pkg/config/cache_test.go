@@ -0,0 +1,67 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Cache recovery", func() {
+	Context("through the external_ids of the port", func() {
+		It("should rebuild the cached state of an attachment", func() {
+			cache := &types.CachedNetConf{
+				Netconf:       &types.NetConf{BrName: "br1", DeviceID: "0000:18:00.2"},
+				OrigIfName:    "ens1f0v1",
+				UserspaceMode: true,
+				VdpaType:      types.VdpaDeviceTypeKernelVhost,
+			}
+			cache.Netconf.IPAM.Type = "host-local"
+			externalIDs := CachedNetConfToExternalIDs(cache)
+
+			netconf := &types.NetConf{DeviceID: "0000:18:00.2"}
+			recovered, err := CachedNetConfFromExternalIDs(netconf, "br1", externalIDs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recovered.Netconf.BrName).To(Equal("br1"))
+			Expect(recovered.Netconf.DeviceID).To(Equal("0000:18:00.2"))
+			Expect(recovered.Netconf.IPAM.Type).To(Equal("host-local"))
+			Expect(recovered.OrigIfName).To(Equal("ens1f0v1"))
+			Expect(recovered.UserspaceMode).To(BeTrue())
+			Expect(recovered.VdpaType).To(Equal(types.VdpaDeviceType(types.VdpaDeviceTypeKernelVhost)))
+		})
+		It("should not change the given netconf", func() {
+			cache := &types.CachedNetConf{Netconf: &types.NetConf{BrName: "br1"}}
+			cache.Netconf.IPAM.Type = "host-local"
+			netconf := &types.NetConf{}
+			_, err := CachedNetConfFromExternalIDs(netconf, "br1", CachedNetConfToExternalIDs(cache))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.BrName).To(BeEmpty())
+			Expect(netconf.IPAM.Type).To(BeEmpty())
+		})
+		It("should use the bridge of a port not recording the state", func() {
+			netconf := &types.NetConf{}
+			netconf.IPAM.Type = "host-local"
+			recovered, err := CachedNetConfFromExternalIDs(netconf, "br1", map[string]string{"owner": "ovs-cni.network.kubevirt.io"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recovered.Netconf.BrName).To(Equal("br1"))
+			Expect(recovered.Netconf.IPAM.Type).To(Equal("host-local"))
+		})
+		It("should fail for a device when the port doesn't record the state", func() {
+			netconf := &types.NetConf{DeviceID: "0000:18:00.2"}
+			_, err := CachedNetConfFromExternalIDs(netconf, "br1", map[string]string{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -20,13 +20,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"dario.cat/mergo"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
 
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/portsecurity"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 )
@@ -34,6 +37,21 @@ import (
 const (
 	linkstateCheckRetries  = 5
 	linkStateCheckInterval = 600 // in milliseconds
+	ovnInstalledTimeout    = 60  // in seconds
+	vhostUserSocketDir     = "/var/run/openvswitch/vhost-user"
+)
+
+// OvnIntegrationBridge is the bridge OVN ports are attached to by default
+const OvnIntegrationBridge = "br-int"
+
+// Keys of the Port external_ids recording the cached state of an attachment
+const (
+	bridgeExternalID        = "bridge"
+	deviceIDExternalID      = "deviceID"
+	origIfNameExternalID    = "origIfName"
+	userspaceModeExternalID = "userspaceMode"
+	vdpaTypeExternalID      = "vdpaType"
+	ipamTypeExternalID      = "ipamType"
 )
 
 // LoadConf parses and validates stdin netconf and returns NetConf object
@@ -58,9 +76,80 @@ func LoadConf(data []byte) (*types.NetConf, error) {
 	if netconf.LinkStateCheckInterval == 0 {
 		netconf.LinkStateCheckInterval = linkStateCheckInterval
 	}
+
+	if netconf.OvnInstalledTimeout == 0 {
+		netconf.OvnInstalledTimeout = ovnInstalledTimeout
+	}
+
+	if err := validateRuntimeConfig(netconf); err != nil {
+		return nil, err
+	}
+
+	if err := validateHostIfaceNaming(netconf); err != nil {
+		return nil, err
+	}
+
+	if netconf.PortSecurity {
+		// the allowed traffic is forwarded with the NORMAL action, which
+		// would bypass the OVN pipeline
+		if netconf.BrName == OvnIntegrationBridge {
+			return nil, fmt.Errorf("portSecurity can't be used on the OVN integration bridge %s", OvnIntegrationBridge)
+		}
+		// the flows are managed through the management socket of the bridge
+		if _, err := portsecurity.Target(netconf.SocketFile, netconf.BrName); err != nil {
+			return nil, err
+		}
+	}
+
+	switch netconf.PortMode {
+	case "", types.PortModeVeth:
+	case types.PortModeInternal:
+		if netconf.DeviceID != "" {
+			return nil, fmt.Errorf("portMode %s can't be used with deviceID", netconf.PortMode)
+		}
+	case types.PortModeVhostUser:
+		if netconf.DeviceID != "" {
+			return nil, fmt.Errorf("portMode %s can't be used with deviceID", netconf.PortMode)
+		}
+		// there is no netdev to install the flows for
+		if netconf.PortSecurity {
+			return nil, fmt.Errorf("portMode %s can't be used with portSecurity", netconf.PortMode)
+		}
+		if netconf.VhostUserSocketDir == "" {
+			netconf.VhostUserSocketDir = vhostUserSocketDir
+		}
+	default:
+		return nil, fmt.Errorf("unsupported portMode %s", netconf.PortMode)
+	}
 	return netconf, nil
 }
 
+// validateRuntimeConfig validates the addresses requested through the "mac"
+// and "ips" runtime capabilities
+func validateRuntimeConfig(netconf *types.NetConf) error {
+	if netconf.RuntimeConfig == nil {
+		return nil
+	}
+
+	if netconf.RuntimeConfig.Mac != "" {
+		if _, err := net.ParseMAC(netconf.RuntimeConfig.Mac); err != nil {
+			return fmt.Errorf("invalid runtimeConfig mac %q: %v", netconf.RuntimeConfig.Mac, err)
+		}
+	}
+
+	for _, ip := range netconf.RuntimeConfig.IPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			return fmt.Errorf("invalid runtimeConfig ip %q: %v", ip, err)
+		}
+	}
+	// the addresses are allocated by the IPAM plugin, which gets them along
+	// with the rest of the configuration
+	if len(netconf.RuntimeConfig.IPs) != 0 && netconf.IPAM.Type == "" {
+		return fmt.Errorf("runtimeConfig ips require an IPAM plugin")
+	}
+	return nil
+}
+
 // LoadMirrorConf parses and validates stdin netconf and returns MirrorNetConf object
 func LoadMirrorConf(data []byte) (*types.MirrorNetConf, error) {
 	netconf, err := loadMirrorNetConf(data)
@@ -78,6 +167,11 @@ func LoadMirrorConf(data []byte) (*types.MirrorNetConf, error) {
 	return netconf, nil
 }
 
+// SavePrevResultConfToCache saves preResult config to cache
+func SavePrevResultConfToCache(cRef string, cache *types.CachedPrevResultNetConf) error {
+	return utils.SaveCache(cRef, cache)
+}
+
 // LoadPrevResultConfFromCache retrieve preResult config from cache
 func LoadPrevResultConfFromCache(cRef string) (*types.CachedPrevResultNetConf, error) {
 	netCache := &types.CachedPrevResultNetConf{}
@@ -93,6 +187,11 @@ func LoadPrevResultConfFromCache(cRef string) (*types.CachedPrevResultNetConf, e
 	return netCache, nil
 }
 
+// SaveConfToCache saves net config to cache
+func SaveConfToCache(cRef string, cache *types.CachedNetConf) error {
+	return utils.SaveCache(cRef, cache)
+}
+
 // LoadConfFromCache retrieve net config from cache
 func LoadConfFromCache(cRef string) (*types.CachedNetConf, error) {
 	netCache := &types.CachedNetConf{}
@@ -108,6 +207,49 @@ func LoadConfFromCache(cRef string) (*types.CachedNetConf, error) {
 	return netCache, nil
 }
 
+// CachedNetConfToExternalIDs returns the Port external_ids recording the
+// state of the attachment kept in the cache, so that it can be rebuilt when
+// the cache is lost
+func CachedNetConfToExternalIDs(cache *types.CachedNetConf) map[string]string {
+	return map[string]string{
+		bridgeExternalID:        cache.Netconf.BrName,
+		deviceIDExternalID:      cache.Netconf.DeviceID,
+		origIfNameExternalID:    cache.OrigIfName,
+		userspaceModeExternalID: strconv.FormatBool(cache.UserspaceMode),
+		vdpaTypeExternalID:      string(cache.VdpaType),
+		ipamTypeExternalID:      cache.Netconf.IPAM.Type,
+	}
+}
+
+// CachedNetConfFromExternalIDs rebuilds the cached state of an attachment
+// from netconf and the external_ids of the Port attached to bridgeName.
+// Ports created by older versions don't record the state, it is then only
+// rebuilt for attachments without a device.
+func CachedNetConfFromExternalIDs(netconf *types.NetConf, bridgeName string, externalIDs map[string]string) (*types.CachedNetConf, error) {
+	cachedNetconf := *netconf
+	cachedNetconf.BrName = bridgeName
+	cache := &types.CachedNetConf{Netconf: &cachedNetconf}
+
+	if _, found := externalIDs[bridgeExternalID]; !found {
+		if netconf.DeviceID != "" {
+			return nil, fmt.Errorf("state of device %s isn't recorded by the port", netconf.DeviceID)
+		}
+		return cache, nil
+	}
+
+	userspaceMode, err := strconv.ParseBool(externalIDs[userspaceModeExternalID])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s recorded by the port: %v", userspaceModeExternalID, err)
+	}
+	cachedNetconf.BrName = externalIDs[bridgeExternalID]
+	cachedNetconf.DeviceID = externalIDs[deviceIDExternalID]
+	cachedNetconf.IPAM.Type = externalIDs[ipamTypeExternalID]
+	cache.OrigIfName = externalIDs[origIfNameExternalID]
+	cache.UserspaceMode = userspaceMode
+	cache.VdpaType = types.VdpaDeviceType(externalIDs[vdpaTypeExternalID])
+	return cache, nil
+}
+
 // GetCRef unique identifier for a container interface
 func GetCRef(cid, podIfName string) string {
 	return strings.Join([]string{cid, podIfName}, "-")
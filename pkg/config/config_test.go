@@ -0,0 +1,44 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Flatfile configuration", func() {
+	var confPath string
+	BeforeEach(func() {
+		confPath = filepath.Join(GinkgoT().TempDir(), "ovs.conf")
+		Expect(os.WriteFile(confPath, []byte(`{"allowedVlans": [{"minID": 100, "maxID": 199}]}`), 0600)).To(Succeed())
+	})
+
+	It("should provide the allowedVlans of networks which don't set them", func() {
+		netconf, err := LoadConf([]byte(fmt.Sprintf(`{"name": "mynet", "type": "ovs", "bridge": "br1", "configuration_path": %q}`, confPath)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netconf.AllowedVlans).To(HaveLen(1))
+		Expect(*netconf.AllowedVlans[0].MinID).To(Equal(uint(100)))
+	})
+
+	It("should not override the allowedVlans of the network", func() {
+		netconf, err := LoadConf([]byte(fmt.Sprintf(`{"name": "mynet", "type": "ovs", "bridge": "br1", "allowedVlans": [{"id": 200}], "configuration_path": %q}`, confPath)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(netconf.AllowedVlans).To(HaveLen(1))
+		Expect(*netconf.AllowedVlans[0].ID).To(Equal(uint(200)))
+	})
+})
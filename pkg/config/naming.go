@@ -0,0 +1,149 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// maxIfaceNameLen is the longest name of a network interface, IFNAMSIZ
+// counts the terminating null byte
+const maxIfaceNameLen = 15
+
+// truncatedNameHashLen is the length of the hash ending the truncated names
+// of the template mode
+const truncatedNameHashLen = 5
+
+// hashNameTemplate is the template of the names of the hash mode
+const hashNameTemplate = "{prefix}{hash}"
+
+var hostIfaceNamePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+var hostIfaceNamePlaceholders = map[string]bool{
+	"{prefix}":       true,
+	"{hash}":         true,
+	"{containerID}":  true,
+	"{ifName}":       true,
+	"{podNamespace}": true,
+	"{podName}":      true,
+}
+
+// validateHostIfaceNaming validates the naming mode and template of the host
+// interfaces
+func validateHostIfaceNaming(netconf *types.NetConf) error {
+	naming := netconf.HostIfaceNaming
+	if naming == nil {
+		return nil
+	}
+	// the ports of the other port modes are named randomly, regardless of
+	// the naming mode
+	if netconf.PortMode != "" && netconf.PortMode != types.PortModeVeth {
+		return fmt.Errorf("hostIfaceNaming isn't supported by portMode %s", netconf.PortMode)
+	}
+
+	switch naming.Mode {
+	case "", types.HostIfaceNamingRandom:
+		return nil
+	case types.HostIfaceNamingHash:
+	case types.HostIfaceNamingTemplate:
+		if naming.Template == "" {
+			return fmt.Errorf("hostIfaceNaming mode %s requires a template", naming.Mode)
+		}
+		for _, placeholder := range hostIfaceNamePlaceholder.FindAllString(naming.Template, -1) {
+			if !hostIfaceNamePlaceholders[placeholder] {
+				return fmt.Errorf("unknown placeholder %s in hostIfaceNaming template %q", placeholder, naming.Template)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported hostIfaceNaming mode %s", naming.Mode)
+	}
+
+	// the host interface of a device is named after the device
+	if netconf.DeviceID != "" {
+		return fmt.Errorf("hostIfaceNaming mode %s is only supported by veth attachments", naming.Mode)
+	}
+	return nil
+}
+
+// HostIfaceName returns the name of the host interface of an attachment, an
+// empty string when it is named randomly. defaultPrefix is the one of the
+// random names. The name is truncated to the longest interface name, the end
+// of a truncated template name being replaced with the hash.
+func HostIfaceName(netconf *types.NetConf, containerID, ifName string, envArgs *types.EnvArgs, defaultPrefix string) (string, error) {
+	naming := netconf.HostIfaceNaming
+	if naming == nil {
+		return "", nil
+	}
+
+	var template string
+	switch naming.Mode {
+	case types.HostIfaceNamingHash:
+		template = hashNameTemplate
+	case types.HostIfaceNamingTemplate:
+		template = naming.Template
+	default:
+		return "", nil
+	}
+
+	prefix := naming.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	var podNamespace, podName string
+	if envArgs != nil {
+		podNamespace = string(envArgs.K8S_POD_NAMESPACE)
+		podName = string(envArgs.K8S_POD_NAME)
+	}
+	sum := sha256.Sum256([]byte(GetCRef(containerID, ifName)))
+	hash := hex.EncodeToString(sum[:])
+
+	name := strings.NewReplacer(
+		"{prefix}", prefix,
+		"{hash}", hash,
+		"{containerID}", containerID,
+		"{ifName}", ifName,
+		"{podNamespace}", podNamespace,
+		"{podName}", podName,
+	).Replace(template)
+	name = strings.Map(sanitizeIfaceNameRune, name)
+	if len(name) > maxIfaceNameLen {
+		name = name[:maxIfaceNameLen]
+		// the truncated names of attachments sharing a long prefix, e.g.
+		// of the replicas of a deployment, are told apart by the hash
+		if naming.Mode == types.HostIfaceNamingTemplate {
+			name = name[:maxIfaceNameLen-truncatedNameHashLen] + hash[:truncatedNameHashLen]
+		}
+	}
+
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid host interface name %q from hostIfaceNaming template %q", name, template)
+	}
+	return name, nil
+}
+
+// sanitizeIfaceNameRune replaces the characters the kernel doesn't accept in
+// interface names, and the non ASCII ones, which may be truncated in the
+// middle, with '-'
+func sanitizeIfaceNameRune(r rune) rune {
+	if r == '/' || r == ':' || unicode.IsSpace(r) || r > unicode.MaxASCII || !unicode.IsPrint(r) {
+		return '-'
+	}
+	return r
+}
@@ -0,0 +1,128 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+var _ = Describe("Host interface naming", func() {
+	loadNaming := func(conf string) *types.NetConf {
+		netconf := &types.NetConf{}
+		Expect(json.Unmarshal([]byte(conf), netconf)).To(Succeed())
+		return netconf
+	}
+	podArgs := func(namespace, name string) *types.EnvArgs {
+		envArgs := &types.EnvArgs{}
+		Expect(envArgs.K8S_POD_NAMESPACE.UnmarshalText([]byte(namespace))).To(Succeed())
+		Expect(envArgs.K8S_POD_NAME.UnmarshalText([]byte(name))).To(Succeed())
+		return envArgs
+	}
+
+	Context("validation", func() {
+		It("should accept the supported modes", func() {
+			for _, conf := range []string{
+				`{}`,
+				`{"hostIfaceNaming": {"mode": "random"}}`,
+				`{"hostIfaceNaming": {"mode": "hash", "prefix": "ovs"}}`,
+				`{"hostIfaceNaming": {"mode": "template", "template": "{prefix}{podName}-{ifName}"}}`,
+			} {
+				Expect(validateHostIfaceNaming(loadNaming(conf))).To(Succeed(), conf)
+			}
+		})
+		It("should reject an unknown mode", func() {
+			Expect(validateHostIfaceNaming(loadNaming(`{"hostIfaceNaming": {"mode": "sequential"}}`))).NotTo(Succeed())
+		})
+		It("should reject a template mode without a valid template", func() {
+			Expect(validateHostIfaceNaming(loadNaming(`{"hostIfaceNaming": {"mode": "template"}}`))).NotTo(Succeed())
+			err := validateHostIfaceNaming(loadNaming(`{"hostIfaceNaming": {"mode": "template", "template": "{podUID}"}}`))
+			Expect(err).To(MatchError(ContainSubstring("unknown placeholder {podUID}")))
+		})
+		It("should reject naming the interfaces of devices and non veth ports", func() {
+			Expect(validateHostIfaceNaming(loadNaming(`{"deviceID": "0000:18:02.5", "hostIfaceNaming": {"mode": "hash"}}`))).NotTo(Succeed())
+			Expect(validateHostIfaceNaming(loadNaming(`{"portMode": "internal", "hostIfaceNaming": {"mode": "hash"}}`))).NotTo(Succeed())
+			Expect(validateHostIfaceNaming(loadNaming(`{"portMode": "internal", "hostIfaceNaming": {"mode": "random"}}`))).NotTo(Succeed())
+			Expect(validateHostIfaceNaming(loadNaming(`{"portMode": "vhost-user", "hostIfaceNaming": {}}`))).NotTo(Succeed())
+			Expect(validateHostIfaceNaming(loadNaming(`{"portMode": "veth", "hostIfaceNaming": {"mode": "hash"}}`))).To(Succeed())
+		})
+	})
+
+	Context("of an attachment", func() {
+		It("should name the interfaces randomly by default", func() {
+			name, err := HostIfaceName(loadNaming(`{}`), "cid", "eth1", nil, "veth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(BeEmpty())
+		})
+		It("should derive the hashed name from the container interface", func() {
+			netconf := loadNaming(`{"hostIfaceNaming": {"mode": "hash"}}`)
+			name, err := HostIfaceName(netconf, "cid", "eth1", nil, "veth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(HaveLen(maxIfaceNameLen))
+			Expect(name).To(HavePrefix("veth"))
+
+			sameName, err := HostIfaceName(netconf, "cid", "eth1", nil, "veth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sameName).To(Equal(name))
+
+			otherName, err := HostIfaceName(netconf, "cid", "eth2", nil, "veth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherName).NotTo(Equal(name))
+		})
+		It("should use the configured prefix", func() {
+			name, err := HostIfaceName(loadNaming(`{"hostIfaceNaming": {"mode": "hash", "prefix": "pod"}}`), "cid", "eth1", nil, "veth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(HavePrefix("pod"))
+		})
+		It("should fill the template and truncate the name", func() {
+			netconf := loadNaming(`{"hostIfaceNaming": {"mode": "template", "prefix": "p-", "template": "{prefix}{podName}.{ifName}"}}`)
+			name, err := HostIfaceName(netconf, "cid", "net1", podArgs("ns1", "web"), "veth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("p-web.net1"))
+
+			name, err = HostIfaceName(netconf, "cid", "net1", podArgs("ns1", "frontend-7d9f8b6c5-x2x4z"), "veth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(HaveLen(maxIfaceNameLen))
+			Expect(name).To(HavePrefix("p-frontend"))
+			Expect(name).NotTo(Equal("p-frontend-7d9f"))
+		})
+		It("should tell apart the truncated names of pods sharing a prefix", func() {
+			netconf := loadNaming(`{"hostIfaceNaming": {"mode": "template", "template": "{podName}"}}`)
+			name, err := HostIfaceName(netconf, "cid1", "net1", podArgs("ns1", "frontend-7d9f8b6c5-x2x4z"), "veth")
+			Expect(err).NotTo(HaveOccurred())
+			otherName, err := HostIfaceName(netconf, "cid2", "net1", podArgs("ns1", "frontend-7d9f8b6c5-k8m2p"), "veth")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(name).To(HaveLen(maxIfaceNameLen))
+			Expect(otherName).To(HaveLen(maxIfaceNameLen))
+			Expect(name[:maxIfaceNameLen-truncatedNameHashLen]).To(Equal("frontend-7"))
+			Expect(otherName[:maxIfaceNameLen-truncatedNameHashLen]).To(Equal("frontend-7"))
+			Expect(otherName).NotTo(Equal(name))
+		})
+		It("should replace the characters invalid in interface names", func() {
+			netconf := loadNaming(`{"hostIfaceNaming": {"mode": "template", "template": "{podNamespace}/{podName}"}}`)
+			name, err := HostIfaceName(netconf, "cid", "eth1", podArgs("ns1", "web"), "veth")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("ns1-web"))
+		})
+		It("should fail when the template gives no name", func() {
+			netconf := loadNaming(`{"hostIfaceNaming": {"mode": "template", "template": "{podName}"}}`)
+			_, err := HostIfaceName(netconf, "cid", "eth1", nil, "veth")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -14,15 +14,34 @@ package deviceinfo
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	netv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 )
 
 const RootDeviceInfoDirectory = "/var/run/k8s.cni.cncf.io/devinfo/cni"
 
+// OvsPort is the ovs port an attachment is connected to
+type OvsPort struct {
+	Bridge string `json:"bridge"`
+	Port   string `json:"port"`
+	Ofport int    `json:"ofport,omitempty"`
+}
+
+// OvsDeviceInfo is the device information of an attachment along with its
+// ovs port. The ovs field extends the device-info spec, consumers of the
+// spec ignore it.
+type OvsDeviceInfo struct {
+	netv1.DeviceInfo
+	Ovs *OvsPort `json:"ovs,omitempty"`
+}
+
 func readDeviceInfo(devInfoPath string) (*netv1.DeviceInfo, error) {
 	devInfoBytes, err := os.ReadFile(devInfoPath)
 	if err != nil {
@@ -45,3 +64,39 @@ func GetDeviceInfo(netconf *types.NetConf) (*netv1.DeviceInfo, error) {
 
 	return readDeviceInfo(netconf.RuntimeConfig.CNIDeviceInfoFile)
 }
+
+// DeviceInfoPath returns the path of the device information file ovs-cni
+// writes for an attachment, identified by its network and its container
+// reference (see config.GetCRef), under RootDeviceInfoDirectory. The file
+// given by the runtime, if any, is an input of the plugin and never written.
+func DeviceInfoPath(netName, cRef string) string {
+	fileName := fmt.Sprintf("%s-%s-device-info.json", netName, cRef)
+	return filepath.Join(RootDeviceInfoDirectory, strings.ReplaceAll(fileName, "/", "-"))
+}
+
+// SaveDeviceInfo writes the device information file consumed by the pod. The
+// file is replaced atomically, e.g. by a retried ADD.
+func SaveDeviceInfo(devInfoPath string, deviceInfo *OvsDeviceInfo) error {
+	devInfoBytes, err := json.Marshal(deviceInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal device info: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(devInfoPath), 0700); err != nil {
+		return fmt.Errorf("failed to create device info directory: %v", err)
+	}
+
+	if err := utils.WriteFileAtomic(devInfoPath, devInfoBytes, 0444); err != nil {
+		return fmt.Errorf("failed to write device info file %s: %v", devInfoPath, err)
+	}
+	return nil
+}
+
+// CleanDeviceInfo removes the device information file, a missing file is
+// not an error
+func CleanDeviceInfo(devInfoPath string) error {
+	if err := os.Remove(devInfoPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove device info file %s: %v", devInfoPath, err)
+	}
+	return nil
+}
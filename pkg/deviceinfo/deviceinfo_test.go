@@ -0,0 +1,87 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceinfo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	netv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+)
+
+var _ = Describe("DeviceInfo", func() {
+	Context("path", func() {
+		It("should derive the file from the attachment", func() {
+			Expect(DeviceInfoPath("ns1/mynet", "cid-net1")).To(Equal(
+				"/var/run/k8s.cni.cncf.io/devinfo/cni/ns1-mynet-cid-net1-device-info.json"))
+		})
+	})
+	Context("file", func() {
+		var tmpDir string
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = os.MkdirTemp("", "ovs-cni-devinfo-test*")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		AfterEach(func() {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+		It("should extend the device information with the ovs port", func() {
+			devInfoPath := filepath.Join(tmpDir, "cni", "devinfo.json")
+			Expect(SaveDeviceInfo(devInfoPath, &OvsDeviceInfo{
+				DeviceInfo: netv1.DeviceInfo{
+					Type:    netv1.DeviceInfoTypePCI,
+					Version: netv1.DeviceInfoVersion,
+					Pci:     &netv1.PciDevice{PciAddress: "0000:18:00.2"},
+				},
+				Ovs: &OvsPort{Bridge: "br1", Port: "eth0_rep", Ofport: 3},
+			})).To(Succeed())
+
+			devInfo, err := readDeviceInfo(devInfoPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devInfo.Type).To(Equal(netv1.DeviceInfoTypePCI))
+			Expect(devInfo.Pci.PciAddress).To(Equal("0000:18:00.2"))
+
+			devInfoBytes, err := os.ReadFile(devInfoPath)
+			Expect(err).NotTo(HaveOccurred())
+			var raw map[string]json.RawMessage
+			Expect(json.Unmarshal(devInfoBytes, &raw)).To(Succeed())
+			Expect(string(raw["ovs"])).To(MatchJSON(`{"bridge": "br1", "port": "eth0_rep", "ofport": 3}`))
+
+			Expect(CleanDeviceInfo(devInfoPath)).To(Succeed())
+			Expect(CleanDeviceInfo(devInfoPath)).To(Succeed())
+			_, err = os.Stat(devInfoPath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("should replace the file of a retried ADD", func() {
+			devInfoPath := filepath.Join(tmpDir, "cni", "devinfo.json")
+			Expect(SaveDeviceInfo(devInfoPath, &OvsDeviceInfo{Ovs: &OvsPort{Bridge: "br1", Port: "veth1"}})).To(Succeed())
+			Expect(SaveDeviceInfo(devInfoPath, &OvsDeviceInfo{Ovs: &OvsPort{Bridge: "br1", Port: "veth2"}})).To(Succeed())
+
+			devInfoBytes, err := os.ReadFile(devInfoPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(devInfoBytes)).To(ContainSubstring("veth2"))
+			entries, err := os.ReadDir(filepath.Dir(devInfoPath))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+
+			Expect(CleanDeviceInfo(devInfoPath)).To(Succeed())
+			_, err = os.Stat(devInfoPath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
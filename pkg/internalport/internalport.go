@@ -0,0 +1,119 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internalport
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+// IsInternalPort returns true when the container interface is an OVS
+// internal port rather than a veth pair
+func IsInternalPort(netconf *types.NetConf) bool {
+	return netconf.PortMode == types.PortModeInternal
+}
+
+// randomPortName returns a random name of the OVS internal port, used until
+// the netdev is renamed in the container namespace
+func randomPortName() (string, error) {
+	entropy := make([]byte, 4)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", fmt.Errorf("failed to generate random port name: %v", err)
+	}
+	return fmt.Sprintf("ovs%x", entropy), nil
+}
+
+// waitNetdev waits until vswitchd creates the kernel netdev of the internal
+// port
+func waitNetdev(name string, retryCount, interval int) (netlink.Link, error) {
+	checkInterval := time.Duration(interval) * time.Millisecond
+	for i := 1; i <= retryCount; i++ {
+		link, err := netlink.LinkByName(name)
+		if err == nil {
+			return link, nil
+		}
+		if i < retryCount {
+			time.Sleep(checkInterval)
+		}
+	}
+	return nil, fmt.Errorf("netdev of the internal port %s was not created, try increasing number of retries/interval config parameter", name)
+}
+
+// SetupInternalPort moves the netdev of the internal port into the container
+// namespace, renames it to the container interface name and sets it up.
+func SetupInternalPort(contNetns ns.NetNS, portName, contIfaceName, requestedMac string, mtu, retryCount, interval int) (*current.Interface, error) {
+	link, err := waitNetdev(portName, retryCount, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := netlink.LinkSetNsFd(link, int(contNetns.Fd())); err != nil {
+		return nil, fmt.Errorf("failed to move %s to container namespace: %v", portName, err)
+	}
+
+	contIface := &current.Interface{}
+	err = contNetns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(portName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %s in container namespace: %v", portName, err)
+		}
+
+		if err := netlink.LinkSetName(link, contIfaceName); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %v", portName, contIfaceName, err)
+		}
+
+		if requestedMac != "" {
+			hwaddr, err := net.ParseMAC(requestedMac)
+			if err != nil {
+				return fmt.Errorf("failed to parse MAC address %s: %v", requestedMac, err)
+			}
+			if err := netlink.LinkSetHardwareAddr(link, hwaddr); err != nil {
+				return fmt.Errorf("failed to set container iface %q MAC %q: %v", contIfaceName, requestedMac, err)
+			}
+		}
+
+		if mtu != 0 {
+			if err := netlink.LinkSetMTU(link, mtu); err != nil {
+				return fmt.Errorf("failed to set container iface %q MTU %d: %v", contIfaceName, mtu, err)
+			}
+		}
+
+		if err := netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+
+		// refetch the link to get its final name and MAC address
+		link, err = netlink.LinkByName(contIfaceName)
+		if err != nil {
+			return err
+		}
+		contIface.Name = link.Attrs().Name
+		contIface.Mac = link.Attrs().HardwareAddr.String()
+		contIface.Sandbox = contNetns.Path()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return contIface, nil
+}
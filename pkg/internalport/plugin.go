@@ -0,0 +1,331 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internalport
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/ns"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/common"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/deviceinfo"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+const internalInterfaceType = "internal"
+
+func CmdAdd(ctx context.Context, args *skel.CmdArgs, netconf *types.NetConf) (err error) {
+	envArgs, err := common.GetEnvArgs(args.Args)
+	if err != nil {
+		return err
+	}
+
+	var mac string
+	var ovnPort string
+	var contPodUid string
+	if envArgs != nil {
+		mac = string(envArgs.MAC)
+		ovnPort = string(envArgs.OvnPort)
+		contPodUid = string(envArgs.K8S_POD_UID)
+	}
+	common.ApplyConfArgsFallback(netconf, &mac, &ovnPort)
+	common.ApplyRuntimeConfigMac(netconf, &mac)
+	if err := common.ApplyVlanArgs(netconf, envArgs); err != nil {
+		return err
+	}
+
+	portCfg, err := common.ParseOvsPortConfig(netconf)
+	if err != nil {
+		return err
+	}
+
+	if err := common.CheckPortSecurityOvnPort(netconf, ovnPort); err != nil {
+		return err
+	}
+
+	common.ResolveWaitForOvnInstalled(netconf, ovnPort)
+
+	bridgeName, err := common.GetBridgeName(netconf.BrName, ovnPort)
+	if err != nil {
+		return err
+	}
+	netconf.BrName = bridgeName
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsDriver.Close()
+
+	if netconf.CreateBridge {
+		if err := common.EnsureBridge(ovsDriver, bridgeName, netconf); err != nil {
+			return err
+		}
+	}
+
+	ovsBridgeDriver, err := ovsDriver.BridgeDriver(bridgeName)
+	if err != nil {
+		return err
+	}
+
+	mtu, err := common.ResolveMTU(ovsBridgeDriver, netconf)
+	if err != nil {
+		return err
+	}
+
+	contNetns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer func() { _ = contNetns.Close() }()
+
+	// undo what has been done so far when ADD fails
+	rollback := &common.Rollback{}
+	defer func() {
+		if err != nil {
+			rollback.Run()
+		}
+	}()
+
+	// Cache NetConf for CmdDel
+	cRef := config.GetCRef(args.ContainerID, args.IfName)
+	cachedNetConf := &types.CachedNetConf{Netconf: netconf, OrigIfName: "", UserspaceMode: false}
+	if err = config.SaveConfToCache(cRef, cachedNetConf); err != nil {
+		return fmt.Errorf("error saving NetConf %q", err)
+	}
+	rollback.Push("cache "+cRef, func() error {
+		return utils.CleanCache(cRef)
+	})
+
+	portName, err := randomPortName()
+	if err != nil {
+		return err
+	}
+
+	if err = ovsBridgeDriver.CreatePort(
+		portName,
+		args.Netns,
+		args.IfName,
+		ovnPort,
+		common.GetOvnPortVersion(netconf, contPodUid),
+		netconf.OfportRequest,
+		portCfg.VlanTag,
+		portCfg.Trunks,
+		portCfg.Type,
+		portCfg.QinQ,
+		portCfg.QoS,
+		internalInterfaceType,
+		nil,
+		contPodUid,
+		args.ContainerID,
+		netconf.Name,
+		common.GetPortExternalIDs(cachedNetConf, envArgs),
+	); err != nil {
+		return err
+	}
+
+	// removing the interface row deletes the netdev, even when it was
+	// already moved to the container namespace
+	rollback.Push("port "+portName, func() error {
+		return ovsBridgeDriver.DeletePort(portName)
+	})
+
+	contIface, err := SetupInternalPort(contNetns, portName, args.IfName, mac, mtu,
+		netconf.LinkStateCheckRetries, netconf.LinkStateCheckInterval)
+	if err != nil {
+		return err
+	}
+
+	if err = common.WaitOvnInstalled(ovsBridgeDriver, netconf, portName); err != nil {
+		return err
+	}
+
+	// the host interface has no netdev in the host namespace, it is only
+	// reported to refer to the OVS port
+	hostIface := &current.Interface{Name: portName}
+
+	result := &current.Result{
+		Interfaces: []*current.Interface{hostIface, contIface},
+	}
+
+	if netconf.IPAM.Type != "" {
+		result, err = common.ManagedIPAMAddCall(
+			ovsBridgeDriver, args, netconf, mac, hostIface, contIface, contNetns, false,
+		)
+		if err != nil {
+			return err
+		}
+		rollback.Push("IPAM allocation", func() error {
+			return ipam.ExecDel(netconf.IPAM.Type, args.StdinData)
+		})
+	}
+
+	if netconf.PortSecurity {
+		rollback.Push("port security flows of "+hostIface.Name, func() error {
+			return common.RemovePortSecurity(ovsBridgeDriver, hostIface.Name)
+		})
+		if err = common.SetupPortSecurity(ovsBridgeDriver, netconf, hostIface.Name, result); err != nil {
+			return err
+		}
+	}
+
+	if err = common.RecordAttachmentAddresses(ovsBridgeDriver, portName, result); err != nil {
+		return err
+	}
+
+	devInfoPath := deviceinfo.DeviceInfoPath(netconf.Name, cRef)
+	if err = common.SaveDeviceInfo(ovsBridgeDriver, devInfoPath, portName, nil); err != nil {
+		return err
+	}
+	rollback.Push("device info "+devInfoPath, func() error {
+		return deviceinfo.CleanDeviceInfo(devInfoPath)
+	})
+
+	return cnitypes.PrintResult(result, netconf.CNIVersion)
+}
+
+func CmdDel(ctx context.Context, args *skel.CmdArgs, cache *types.CachedNetConf) error {
+	envArgs, err := common.GetEnvArgs(args.Args)
+	if err != nil {
+		return err
+	}
+
+	var ovnPort string
+	if envArgs != nil {
+		ovnPort = string(envArgs.OvnPort)
+	}
+	common.ApplyConfArgsFallback(cache.Netconf, nil, &ovnPort)
+
+	bridgeName, err := common.GetBridgeName(cache.Netconf.BrName, ovnPort)
+	if err != nil {
+		return err
+	}
+
+	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(ctx, bridgeName, cache.Netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsBridgeDriver.Close()
+
+	if cache.Netconf.IPAM.Type != "" {
+		err = ipam.ExecDel(cache.Netconf.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The port is looked up by the container ID, the CNI_NETNS parameter
+	// may be empty according to version 0.4.0 of the CNI spec
+	// (https://github.com/containernetworking/cni/blob/spec-v0.4.0/SPEC.md).
+	port, err := ovsBridgeDriver.FindContainerPort(args.ContainerID, args.IfName)
+	if err != nil {
+		return fmt.Errorf("Failed to obtain OVS port for given connection: %v", err)
+	}
+	if port == nil {
+		return nil
+	}
+
+	if cache.Netconf.PortSecurity {
+		// the flows must not prevent the port from being removed
+		if err := common.RemovePortSecurity(ovsBridgeDriver, port.Name); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+
+	// Removing the interface row makes vswitchd delete the netdev, wherever
+	// it is. This also covers the case of an already removed namespace.
+	return common.RemoveOvsPort(ovsBridgeDriver, port.Name)
+}
+
+func CmdCheck(ctx context.Context, args *skel.CmdArgs, netconf *types.NetConf) error {
+	envArgs, err := common.GetEnvArgs(args.Args)
+	if err != nil {
+		return err
+	}
+	var ovnPort string
+	if envArgs != nil {
+		ovnPort = string(envArgs.OvnPort)
+	}
+	common.ApplyConfArgsFallback(netconf, nil, &ovnPort)
+	if err := common.ApplyVlanArgs(netconf, envArgs); err != nil {
+		return err
+	}
+
+	// Discover bridge name
+	bridgeName, err := common.GetBridgeName(netconf.BrName, ovnPort)
+	if err != nil {
+		return err
+	}
+	netconf.BrName = bridgeName
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsDriver.Close()
+
+	// check cache
+	if _, err := common.CacheLoadAndCheck(ovsDriver, args, netconf); err != nil {
+		return err
+	}
+
+	// run the IPAM plugin
+	if netconf.NetConf.IPAM.Type != "" {
+		if err := ipam.ExecCheck(netconf.NetConf.IPAM.Type, args.StdinData); err != nil {
+			return fmt.Errorf("failed to check with IPAM plugin type %q: %v", netconf.NetConf.IPAM.Type, err)
+		}
+	}
+
+	result, err := common.ParsePrevResult(netconf)
+	if err != nil {
+		return err
+	}
+
+	// the host interface refers to the OVS port only, there is no netdev
+	// to validate in the host namespace
+	var hostIntf, contIntf current.Interface
+	for _, intf := range result.Interfaces {
+		if intf.Sandbox == "" {
+			hostIntf = *intf
+		} else if intf.Name == args.IfName && intf.Sandbox == args.Netns {
+			contIntf = *intf
+		}
+	}
+	if args.Netns != contIntf.Sandbox {
+		return fmt.Errorf("Sandbox in prevResult %s doesn't match configured netns: %s",
+			contIntf.Sandbox, args.Netns)
+	}
+
+	if err := common.ValidateNetnsAttachment(args, result, contIntf, false); err != nil {
+		return err
+	}
+
+	// ovs specific check
+	if err := common.ValidateOvs(ovsDriver, args, netconf, hostIntf.Name); err != nil {
+		return err
+	}
+
+	if netconf.PortSecurity {
+		return common.ValidatePortSecurity(ovsDriver, netconf, hostIntf.Name, result)
+	}
+	return nil
+}
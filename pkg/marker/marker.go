@@ -19,14 +19,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/cache"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
 const (
@@ -47,10 +49,12 @@ type Marker struct {
 	nodeName  string
 	clientset kubernetes.Interface
 	ovsdb     *ovsdb.OvsDriver
+	// when the ports in error state were first seen, by port name
+	errorPortsSince map[string]time.Time
 }
 
 // NewMarker creates new Marker object
-func NewMarker(nodeName string, ovsSocket string) (*Marker, error) {
+func NewMarker(nodeName string, ovsdbConfig types.OvsdbConfig) (*Marker, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("Error while obtaining cluster config: %v", err)
@@ -61,12 +65,12 @@ func NewMarker(nodeName string, ovsSocket string) (*Marker, error) {
 		return nil, fmt.Errorf("Error building example clientset: %v", err)
 	}
 
-	ovsDriver, err := ovsdb.NewOvsDriver(ovsSocket)
+	ovsDriver, err := ovsdb.NewOvsDriver(context.Background(), ovsdbConfig)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating the ovsdb connection: %v", err)
 	}
 
-	return &Marker{clientset: clientset, nodeName: nodeName, ovsdb: ovsDriver}, nil
+	return &Marker{clientset: clientset, nodeName: nodeName, ovsdb: ovsDriver, errorPortsSince: make(map[string]time.Time)}, nil
 }
 
 func (m *Marker) getAvailableResources() (map[string]bool, error) {
@@ -146,7 +150,7 @@ func (m *Marker) Update(cache *cache.Cache) error {
 	_, err = m.clientset.
 		CoreV1().
 		Nodes().
-		Patch(context.TODO(), m.nodeName, types.JSONPatchType, payloadBytes, metav1.PatchOptions{}, "status")
+		Patch(context.TODO(), m.nodeName, k8stypes.JSONPatchType, payloadBytes, metav1.PatchOptions{}, "status")
 	if err != nil {
 		return fmt.Errorf("failed to apply patch %s on node: %v", payloadBytes, err)
 	}
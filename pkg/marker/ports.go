@@ -0,0 +1,111 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/portsecurity"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+// CleanErrorPorts removes the ports created by ovs-cni whose interface has
+// been in error state for longer than the grace period, e.g. the ports left
+// behind by a container namespace removed before DEL. It returns the removed
+// ports.
+func (m *Marker) CleanErrorPorts(gracePeriod time.Duration) ([]ovsdb.ErrorPort, error) {
+	errorPorts, err := m.ovsdb.FindOwnedPortsWithError()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find ports in error state: %v", err)
+	}
+
+	var removed []ovsdb.ErrorPort
+	for _, port := range expiredErrorPorts(errorPorts, m.errorPortsSince, time.Now(), gracePeriod) {
+		deleted, err := m.removeErrorPort(port)
+		if err != nil {
+			// Don't give up on the other ports, the port will be
+			// retried on the next run if it still exists.
+			glog.Errorf("Failed to remove port %s of bridge %s: %v", port.Name, port.Bridge, err)
+			continue
+		}
+		delete(m.errorPortsSince, port.Name)
+		if deleted {
+			removed = append(removed, port)
+		}
+	}
+	return removed, nil
+}
+
+// removeErrorPort removes the port and its port security flows if its
+// interface is still in error state, holding the lock of its bridge. It
+// returns whether the port was removed.
+func (m *Marker) removeErrorPort(port ovsdb.ErrorPort) (bool, error) {
+	bridgeDriver, err := m.ovsdb.BridgeDriver(port.Bridge)
+	if err != nil {
+		return false, err
+	}
+
+	lock, err := utils.LockBridge(port.Bridge, bridgeDriver.TransactTimeout())
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+
+	// the interface of ovs-cni ports is named after the port
+	hasError, err := bridgeDriver.InterfaceHasError(port.Name)
+	if err != nil || !hasError {
+		return false, err
+	}
+	// the flows are identified by a cookie derived from the port name and
+	// would apply to the next port with the same name. Whether the port had
+	// port security isn't recorded, removing no flows is harmless. The flows
+	// can only be managed through a local ovsdb socket.
+	if target, err := portsecurity.Target(bridgeDriver.SocketFile(), port.Bridge); err != nil {
+		glog.Warningf("Not removing the port security flows of port %s: %v", port.Name, err)
+	} else if err := portsecurity.DelFlows(target, port.Name); err != nil {
+		return false, err
+	}
+	if err := bridgeDriver.DeletePort(port.Name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// expiredErrorPorts records in since when the ports in error state were
+// first seen, forgetting the ones which aren't in error anymore, and returns
+// the ports seen in error for at least the grace period
+func expiredErrorPorts(errorPorts []ovsdb.ErrorPort, since map[string]time.Time, now time.Time, gracePeriod time.Duration) []ovsdb.ErrorPort {
+	current := make(map[string]bool, len(errorPorts))
+	var expired []ovsdb.ErrorPort
+	for _, port := range errorPorts {
+		current[port.Name] = true
+		firstSeen, found := since[port.Name]
+		if !found {
+			since[port.Name] = now
+			firstSeen = now
+		}
+		if now.Sub(firstSeen) >= gracePeriod {
+			expired = append(expired, port)
+		}
+	}
+	for name := range since {
+		if !current[name] {
+			delete(since, name)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,57 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+)
+
+var _ = Describe("expiredErrorPorts", func() {
+	const gracePeriod = time.Minute
+	var (
+		since map[string]time.Time
+		now   time.Time
+		port1 = ovsdb.ErrorPort{Bridge: "br1", Name: "veth1", Error: "could not open network device veth1 (No such device)"}
+		port2 = ovsdb.ErrorPort{Bridge: "br1", Name: "veth2", Error: "could not open network device veth2 (No such device)"}
+	)
+	BeforeEach(func() {
+		since = make(map[string]time.Time)
+		now = time.Now()
+	})
+
+	It("should not return ports newly in error state", func() {
+		Expect(expiredErrorPorts([]ovsdb.ErrorPort{port1}, since, now, gracePeriod)).To(BeEmpty())
+		Expect(since).To(HaveKeyWithValue("veth1", now))
+	})
+
+	It("should return the ports in error state for the grace period", func() {
+		expiredErrorPorts([]ovsdb.ErrorPort{port1}, since, now, gracePeriod)
+		Expect(expiredErrorPorts([]ovsdb.ErrorPort{port1, port2}, since, now.Add(gracePeriod), gracePeriod)).To(ConsistOf(port1))
+	})
+
+	It("should forget the ports which are not in error state anymore", func() {
+		expiredErrorPorts([]ovsdb.ErrorPort{port1}, since, now, gracePeriod)
+		Expect(expiredErrorPorts(nil, since, now.Add(gracePeriod/2), gracePeriod)).To(BeEmpty())
+		Expect(since).To(BeEmpty())
+		Expect(expiredErrorPorts([]ovsdb.ErrorPort{port1}, since, now.Add(gracePeriod), gracePeriod)).To(BeEmpty())
+	})
+
+	It("should return every port with a zero grace period", func() {
+		Expect(expiredErrorPorts([]ovsdb.ErrorPort{port1, port2}, since, now, 0)).To(ConsistOf(port1, port2))
+	})
+})
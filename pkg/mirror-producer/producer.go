@@ -21,6 +21,7 @@
 package plugin
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -87,10 +88,19 @@ func CmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
-	ovsDriver, err := ovsdb.NewOvsBridgeDriver(netconf.BrName, netconf.SocketFile)
+	ovsDriver, err := ovsdb.NewOvsBridgeDriver(context.Background(), netconf.BrName, netconf.OvsdbConfig)
 	if err != nil {
 		return err
 	}
+	defer ovsDriver.Close()
+
+	// serialize the changes of the mirrors of the bridge with the other
+	// invocations of the node
+	lock, err := utils.LockBridge(netconf.BrName, ovsDriver.TransactTimeout())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
 
 	// removes all empty mirrors
 	if err := ovsDriver.CleanEmptyMirrors(); err != nil {
@@ -98,7 +108,7 @@ func CmdAdd(args *skel.CmdArgs) error {
 	}
 
 	// Cache PrevResult for CmdDel
-	if err = utils.SaveCache(config.GetCRef(args.ContainerID, args.IfName)+"_prod",
+	if err = config.SavePrevResultConfToCache(config.GetCRef(args.ContainerID, args.IfName)+"_prod",
 		&types.CachedPrevResultNetConf{PrevResult: netconf.PrevResult}); err != nil {
 		return fmt.Errorf("error saving NetConf %q", err)
 	}
@@ -158,10 +168,19 @@ func CmdDel(args *skel.CmdArgs) error {
 	// add prevResult, because missing in CNI spec < 0.4.0
 	netconf.PrevResult = cache.PrevResult
 
-	ovsDriver, err := ovsdb.NewOvsBridgeDriver(netconf.BrName, netconf.SocketFile)
+	ovsDriver, err := ovsdb.NewOvsBridgeDriver(context.Background(), netconf.BrName, netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsDriver.Close()
+
+	// serialize the changes of the mirrors of the bridge with the other
+	// invocations of the node
+	lock, err := utils.LockBridge(netconf.BrName, ovsDriver.TransactTimeout())
 	if err != nil {
 		return err
 	}
+	defer lock.Unlock()
 
 	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces)
 	if err != nil {
@@ -190,7 +209,8 @@ func CmdDel(args *skel.CmdArgs) error {
 		// if this mirror is not used we can remove it
 		if !used {
 			err = ovsDriver.DeleteMirror(netconf.BrName, mirror.Name)
-			if err != nil {
+			// a port attached meanwhile keeps the mirror in use
+			if err != nil && !errors.Is(err, ovsdb.ErrMirrorInUse) {
 				return fmt.Errorf("cannot delete mirror %s: %v ", mirror.Name, err)
 			}
 		}
@@ -217,10 +237,11 @@ func CmdCheck(args *skel.CmdArgs) error {
 		return err
 	}
 
-	ovsDriver, err := ovsdb.NewOvsBridgeDriver(netconf.BrName, netconf.SocketFile)
+	ovsDriver, err := ovsdb.NewOvsBridgeDriver(context.Background(), netconf.BrName, netconf.OvsdbConfig)
 	if err != nil {
 		return err
 	}
+	defer ovsDriver.Close()
 
 	portUUID, err := getPortUUID(ovsDriver, netconf.PrevResult.Interfaces)
 	if err != nil {
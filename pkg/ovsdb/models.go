@@ -0,0 +1,118 @@
+// Copyright 2019 Red Hat Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"github.com/ovn-org/libovsdb/model"
+)
+
+const (
+	bridgeTable    = "Bridge"
+	ovsTable       = "Open_vSwitch"
+	portTable      = "Port"
+	interfaceTable = "Interface"
+	mirrorTable    = "Mirror"
+	qosTable       = "QoS"
+	queueTable     = "Queue"
+)
+
+// The models only hold the columns used by ovs-cni, which are the ones
+// monitored into the client cache. Optional columns, i.e. sets of at most one
+// element, are pointers which are nil when the set is empty.
+
+// OpenvSwitch defines an object in Open_vSwitch table
+type OpenvSwitch struct {
+	UUID    string   `ovsdb:"_uuid"`
+	Bridges []string `ovsdb:"bridges"`
+}
+
+// Bridge defines an object in Bridge table
+type Bridge struct {
+	UUID         string            `ovsdb:"_uuid"`
+	Name         string            `ovsdb:"name"`
+	Ports        []string          `ovsdb:"ports"`
+	Mirrors      []string          `ovsdb:"mirrors"`
+	DatapathType string            `ovsdb:"datapath_type"`
+	FailMode     *string           `ovsdb:"fail_mode"`
+	ExternalIDs  map[string]string `ovsdb:"external_ids"`
+}
+
+// Port defines an object in Port table
+type Port struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Name        string            `ovsdb:"name"`
+	Interfaces  []string          `ovsdb:"interfaces"`
+	Tag         *int              `ovsdb:"tag"`
+	Trunks      []int             `ovsdb:"trunks"`
+	VlanMode    *string           `ovsdb:"vlan_mode"`
+	Cvlans      []int             `ovsdb:"cvlans"`
+	QoS         *string           `ovsdb:"qos"`
+	OtherConfig map[string]string `ovsdb:"other_config"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+// Interface defines an object in Interface table
+type Interface struct {
+	UUID                 string            `ovsdb:"_uuid"`
+	Name                 string            `ovsdb:"name"`
+	Type                 string            `ovsdb:"type"`
+	Options              map[string]string `ovsdb:"options"`
+	ExternalIDs          map[string]string `ovsdb:"external_ids"`
+	OfportRequest        *int              `ovsdb:"ofport_request"`
+	IngressPolicingRate  int               `ovsdb:"ingress_policing_rate"`
+	IngressPolicingBurst int               `ovsdb:"ingress_policing_burst"`
+	MTU                  *int              `ovsdb:"mtu"`
+	LinkState            *string           `ovsdb:"link_state"`
+	Ofport               *int              `ovsdb:"ofport"`
+	Error                *string           `ovsdb:"error"`
+}
+
+// Mirror defines an object in Mirror table
+type Mirror struct {
+	UUID          string            `ovsdb:"_uuid"`
+	Name          string            `ovsdb:"name"`
+	SelectSrcPort []string          `ovsdb:"select_src_port"`
+	SelectDstPort []string          `ovsdb:"select_dst_port"`
+	OutputPort    *string           `ovsdb:"output_port"`
+	ExternalIDs   map[string]string `ovsdb:"external_ids"`
+}
+
+// QoS defines an object in QoS table
+type QoS struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Type        string            `ovsdb:"type"`
+	Queues      map[int]string    `ovsdb:"queues"`
+	OtherConfig map[string]string `ovsdb:"other_config"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+// Queue defines an object in Queue table
+type Queue struct {
+	UUID        string            `ovsdb:"_uuid"`
+	OtherConfig map[string]string `ovsdb:"other_config"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+// newClientDBModel returns the client model of the Open_vSwitch database
+func newClientDBModel() (model.ClientDBModel, error) {
+	return model.NewClientDBModel("Open_vSwitch", map[string]model.Model{
+		ovsTable:       &OpenvSwitch{},
+		bridgeTable:    &Bridge{},
+		portTable:      &Port{},
+		interfaceTable: &Interface{},
+		mirrorTable:    &Mirror{},
+		qosTable:       &QoS{},
+		queueTable:     &Queue{},
+	})
+}
@@ -17,41 +17,102 @@ package ovsdb
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
-	"reflect"
-
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/ovn-org/libovsdb/cache"
 	"github.com/ovn-org/libovsdb/client"
 	"github.com/ovn-org/libovsdb/model"
 	"github.com/ovn-org/libovsdb/ovsdb"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
 const ovsPortOwner = "ovs-cni.network.kubevirt.io"
 const defaultOVSSocket = "unix:/var/run/openvswitch/db.sock"
+const sslSocketPrefix = "ssl:"
+
 const (
-	bridgeTable = "Bridge"
-	ovsTable    = "Open_vSwitch"
+	defaultConnectTimeout  = 10 * time.Second
+	defaultTransactTimeout = 10 * time.Second
+	// connectRetries bounds the attempts to connect while ovsdb-server is
+	// (re)starting, within the connect timeout
+	connectRetries = 5
 )
 
 var (
 	errObjectNotFound = errors.New("object not found")
+	// errConditionNotMet is returned when a wait operation of a transaction
+	// finds the database in another state than expected
+	errConditionNotMet = errors.New("condition not met")
+	// ErrMirrorInUse is returned when deleting a mirror which got a port
+	// attached meanwhile
+	ErrMirrorInUse = errors.New("mirror in use")
 )
 
-// Bridge defines an object in Bridge table
-type Bridge struct {
-	UUID string `ovsdb:"_uuid"`
+// PortQoS bandwidth limits of a port, zero values mean no limit
+type PortQoS struct {
+	// IngressPolicingRate rate in kbps of traffic received from the interface
+	IngressPolicingRate uint
+	// IngressPolicingBurst burst in kb of traffic received from the interface
+	IngressPolicingBurst uint
+	// EgressRate rate in bps of traffic sent to the interface
+	EgressRate uint64
+	// EgressBurst burst in bits of traffic sent to the interface
+	EgressBurst uint64
+}
+
+// PortQinQ 802.1ad settings of a dot1q-tunnel port
+type PortQinQ struct {
+	// Cvlans customer VLANs allowed on the port, all of them when empty
+	Cvlans []uint
+	// Ethtype ethertype of the service VLAN tag, "802.1ad" or "802.1q"
+	Ethtype string
 }
 
-// OpenvSwitch defines an object in Open_vSwitch table
-type OpenvSwitch struct {
-	UUID string `ovsdb:"_uuid"`
+// BridgeOptions optional settings of a bridge created by ovs-cni
+type BridgeOptions struct {
+	// Uplink name of an existing host interface attached to the bridge
+	Uplink string
+	// DatapathType datapath type of the bridge, e.g. "netdev"
+	DatapathType string
+	// FailMode fail mode of the bridge, "standalone" or "secure"
+	FailMode string
 }
 
 // OvsDriver OVS driver state
 type OvsDriver struct {
 	// OVS client
 	ovsClient client.Client
+
+	// goroutines waiting for the updates of the monitored interfaces
+	interfaceWatchers *interfaceWatchers
+
+	// context of the driver calls, e.g. of a CNI invocation
+	ctx context.Context
+
+	// bound of each transaction, including the wait for a reconnection
+	transactTimeout time.Duration
+
+	// ovsdb endpoint the driver is connected to
+	socketFile string
+}
+
+// interfaceWatchers channels notified of the updates of the monitored
+// interfaces, by name. Several goroutines may wait for the same interface.
+type interfaceWatchers struct {
+	sync.Mutex
+	channels map[string]map[chan Interface]bool
 }
 
 // OvsBridgeDriver OVS bridge driver state
@@ -68,62 +129,226 @@ const (
 	MirrorConsumer
 )
 
-// connectToOvsDb connect to ovsdb
-func connectToOvsDb(ovsSocket string) (client.Client, error) {
-	dbmodel, err := model.NewClientDBModel("Open_vSwitch",
-		map[string]model.Model{bridgeTable: &Bridge{}, ovsTable: &OpenvSwitch{}})
+// connectToOvsDb connect to ovsdb and monitors the columns the driver reads,
+// see newMonitor. Reads are served by the client cache, only transactions
+// reach the server. Connecting is retried with backoff until the connect
+// timeout expires, a lost connection is reestablished in the background.
+func connectToOvsDb(ctx context.Context, config types.OvsdbConfig) (client.Client, error) {
+	dbmodel, err := newClientDBModel()
 	if err != nil {
 		return nil, fmt.Errorf("unable to create DB model error: %v", err)
 	}
 
-	ovsDB, err := client.NewOVSDBClient(dbmodel, client.WithEndpoint(ovsSocket))
+	connectTimeout := durationOrDefault(config.ConnectTimeout, defaultConnectTimeout)
+	options := []client.Option{
+		client.WithEndpoint(config.SocketFile),
+		client.WithReconnect(connectTimeout, newReconnectBackoff()),
+	}
+	if strings.HasPrefix(config.SocketFile, sslSocketPrefix) {
+		tlsConfig, err := NewTLSConfig(config.SSLConfig)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, client.WithTLSConfig(tlsConfig))
+	}
+
+	ovsDB, err := client.NewOVSDBClient(dbmodel, options...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create DB client error: %v", err)
 	}
-	err = ovsDB.Connect(context.Background())
-	if err != nil {
+
+	connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	connect := func() error {
+		err := ovsDB.Connect(connectCtx)
+		if err != nil {
+			log.Printf("Warning: failed to connect to ovsdb %s: %v", config.SocketFile, err)
+		}
+		return err
+	}
+	retries := backoff.WithContext(backoff.WithMaxRetries(newConnectBackoff(), connectRetries), connectCtx)
+	if err = backoff.Retry(connect, retries); err != nil {
+		ovsDB.Close()
 		return nil, fmt.Errorf("failed to connect to ovsdb error: %v", err)
 	}
 
+	if _, err = ovsDB.Monitor(connectCtx, newMonitor(ovsDB)); err != nil {
+		ovsDB.Close()
+		return nil, fmt.Errorf("failed to monitor ovsdb error: %v", err)
+	}
+
 	return ovsDB, nil
 }
 
-// NewOvsDriver Create a new OVS driver with Unix socket
-func NewOvsDriver(ovsSocket string) (*OvsDriver, error) {
+// newMonitor returns the monitor of the columns the driver reads, the other
+// columns are only written. Ports and interfaces not owned by ovs-cni are
+// read too, e.g. the uplinks of a bridge, while the QoS and Queue rows are
+// only read when created by ovs-cni.
+func newMonitor(ovsDB client.Client) *client.Monitor {
+	ovs := &OpenvSwitch{}
+	bridge := &Bridge{}
+	port := &Port{}
+	intf := &Interface{}
+	mirror := &Mirror{}
+	qos := &QoS{}
+	queue := &Queue{}
+	owned := func(externalIDs *map[string]string) []model.Condition {
+		return []model.Condition{{
+			Field:    externalIDs,
+			Function: ovsdb.ConditionIncludes,
+			Value:    map[string]string{"owner": ovsPortOwner},
+		}}
+	}
+
+	return ovsDB.NewMonitor(
+		client.WithTable(ovs, &ovs.Bridges),
+		client.WithTable(bridge, &bridge.Name, &bridge.Ports, &bridge.Mirrors, &bridge.DatapathType),
+		client.WithTable(port, &port.Name, &port.Interfaces, &port.Tag, &port.Trunks, &port.VlanMode,
+			&port.Cvlans, &port.QoS, &port.OtherConfig, &port.ExternalIDs),
+		client.WithTable(intf, &intf.Name, &intf.Type, &intf.Options, &intf.ExternalIDs, &intf.IngressPolicingRate,
+			&intf.IngressPolicingBurst, &intf.MTU, &intf.LinkState, &intf.Ofport, &intf.Error),
+		client.WithTable(mirror, &mirror.Name, &mirror.SelectSrcPort, &mirror.SelectDstPort, &mirror.OutputPort,
+			&mirror.ExternalIDs),
+		client.WithConditionalTable(qos, owned(&qos.ExternalIDs), &qos.Queues, &qos.OtherConfig, &qos.ExternalIDs),
+		client.WithConditionalTable(queue, owned(&queue.ExternalIDs), &queue.OtherConfig),
+	)
+}
+
+// newConnectBackoff returns the backoff between the first attempts to connect
+func newConnectBackoff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 200 * time.Millisecond
+	b.MaxInterval = 2 * time.Second
+	return b
+}
+
+// newReconnectBackoff returns the backoff between the attempts to reconnect,
+// which never gives up: the client would panic otherwise. Transactions are
+// bounded by their own timeout meanwhile.
+func newReconnectBackoff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 200 * time.Millisecond
+	b.MaxInterval = 5 * time.Second
+	b.MaxElapsedTime = 0
+	return b
+}
+
+// durationOrDefault converts a timeout in milliseconds, zero meaning the
+// default one
+func durationOrDefault(milliseconds int, defaultDuration time.Duration) time.Duration {
+	if milliseconds <= 0 {
+		return defaultDuration
+	}
+	return time.Duration(milliseconds) * time.Millisecond
+}
+
+// NewTLSConfig returns the TLS configuration of an ssl: endpoint, which
+// requires a client certificate. Same as the OVS utilities, the server
+// certificate is only verified against the CA certificate: certificates of
+// the OVS PKI don't carry the server address.
+func NewTLSConfig(sslConfig types.SSLConfig) (*tls.Config, error) {
+	if sslConfig.CACert == "" || sslConfig.Cert == "" || sslConfig.Key == "" {
+		return nil, fmt.Errorf("ssl socket requires a CA certificate, a certificate and a private key")
+	}
+
+	cert, err := tls.LoadX509KeyPair(sslConfig.Cert, sslConfig.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate %s and private key %s: %v", sslConfig.Cert, sslConfig.Key, err)
+	}
+
+	caCert, err := os.ReadFile(sslConfig.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificate found in %s", sslConfig.CACert)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// the default verification checks the server name too, the chain
+		// is verified by VerifyPeerCertificate instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyServerCertificate(rawCerts, caPool)
+		},
+	}, nil
+}
+
+// verifyServerCertificate verifies the certificate chain sent by the server
+// against the CA certificates
+func verifyServerCertificate(rawCerts [][]byte, caPool *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("ovsdb server sent no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	var serverCert *x509.Certificate
+	for i, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return fmt.Errorf("failed to parse ovsdb server certificate: %v", err)
+		}
+		if i == 0 {
+			serverCert = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	_, err := serverCert.Verify(x509.VerifyOptions{
+		Roots:         caPool,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// NewOvsDriver Create a new OVS driver connected to the config socket. ctx
+// bounds the calls of the driver, which must be closed once done.
+func NewOvsDriver(ctx context.Context, config types.OvsdbConfig) (*OvsDriver, error) {
 	ovsDriver := new(OvsDriver)
 
-	if ovsSocket == "" {
-		ovsSocket = defaultOVSSocket
+	if config.SocketFile == "" {
+		config.SocketFile = defaultOVSSocket
 	}
 
-	ovsDB, err := connectToOvsDb(ovsSocket)
+	ovsDB, err := connectToOvsDb(ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to ovsdb error: %v", err)
+		return nil, fmt.Errorf("failed to connect to ovsdb socket %s: error: %v", config.SocketFile, err)
 	}
 
 	ovsDriver.ovsClient = ovsDB
+	ovsDriver.ctx = ctx
+	ovsDriver.transactTimeout = durationOrDefault(config.TransactTimeout, defaultTransactTimeout)
+	ovsDriver.socketFile = config.SocketFile
+	ovsDriver.handleNotifications()
 
 	return ovsDriver, nil
 }
 
-// NewOvsBridgeDriver Create a new OVS driver for a bridge with Unix socket
-func NewOvsBridgeDriver(bridgeName, socketFile string) (*OvsBridgeDriver, error) {
-	ovsDriver := new(OvsBridgeDriver)
-
-	if socketFile == "" {
-		socketFile = defaultOVSSocket
+// NewOvsBridgeDriver Create a new OVS driver for a bridge, which must exist.
+// The driver must be closed once done.
+func NewOvsBridgeDriver(ctx context.Context, bridgeName string, config types.OvsdbConfig) (*OvsBridgeDriver, error) {
+	ovsDriver, err := NewOvsDriver(ctx, config)
+	if err != nil {
+		return nil, err
 	}
 
-	ovsDB, err := connectToOvsDb(socketFile)
+	ovsBridgeDriver, err := ovsDriver.BridgeDriver(bridgeName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to ovsdb socket %s: error: %v", socketFile, err)
+		ovsDriver.Close()
+		return nil, err
 	}
 
-	// Setup state
-	ovsDriver.ovsClient = ovsDB
-	ovsDriver.OvsBridgeName = bridgeName
+	return ovsBridgeDriver, nil
+}
 
-	bridgeExist, err := ovsDriver.IsBridgePresent(bridgeName)
+// BridgeDriver returns a driver for a bridge, which must exist, sharing the
+// connection of ovsd. It must not be used once ovsd is closed.
+func (ovsd *OvsDriver) BridgeDriver(bridgeName string) (*OvsBridgeDriver, error) {
+	bridgeExist, err := ovsd.IsBridgePresent(bridgeName)
 	if err != nil {
 		return nil, err
 	}
@@ -132,14 +357,36 @@ func NewOvsBridgeDriver(bridgeName, socketFile string) (*OvsBridgeDriver, error)
 		return nil, fmt.Errorf("failed to find bridge %s", bridgeName)
 	}
 
-	// Return the new OVS driver
-	return ovsDriver, nil
+	return &OvsBridgeDriver{OvsDriver: *ovsd, OvsBridgeName: bridgeName}, nil
+}
+
+// Close closes the connection to ovsdb, shared by the bridge drivers
+// returned by BridgeDriver
+func (ovsd *OvsDriver) Close() {
+	ovsd.ovsClient.Close()
+}
+
+// TransactTimeout returns the bound of each transaction of the driver
+func (ovsd *OvsDriver) TransactTimeout() time.Duration {
+	return ovsd.transactTimeout
+}
+
+// SocketFile returns the ovsdb endpoint the driver is connected to
+func (ovsd *OvsDriver) SocketFile() string {
+	return ovsd.socketFile
 }
 
 // Wrapper for ovsDB transaction
 func (ovsd *OvsDriver) ovsdbTransact(ops []ovsdb.Operation) ([]ovsdb.OperationResult, error) {
-	// Perform OVSDB transaction
-	reply, _ := ovsd.ovsClient.Transact(context.Background(), ops...)
+	ctx, cancel := context.WithTimeout(ovsd.ctx, ovsd.transactTimeout)
+	defer cancel()
+
+	// Perform OVSDB transaction, the client waits for a lost connection to
+	// be reestablished until the timeout expires
+	reply, err := ovsd.ovsClient.Transact(ctx, ops...)
+	if err != nil {
+		return nil, fmt.Errorf("OVS transaction failed: %v", err)
+	}
 
 	if len(reply) < len(ops) {
 		return nil, errors.New("OVS transaction failed. Less replies than operations")
@@ -147,6 +394,10 @@ func (ovsd *OvsDriver) ovsdbTransact(ops []ovsdb.Operation) ([]ovsdb.OperationRe
 
 	// Parse reply and look for errors
 	for _, o := range reply {
+		if o.Error == "timed out" {
+			// RFC 7047 5.2.6, only wait operations time out
+			return nil, fmt.Errorf("OVS Transaction failed: %w", errConditionNotMet)
+		}
 		if o.Error != "" {
 			return nil, errors.New("OVS Transaction failed err " + o.Error + " Details: " + o.Details)
 		}
@@ -158,103 +409,143 @@ func (ovsd *OvsDriver) ovsdbTransact(ops []ovsdb.Operation) ([]ovsdb.OperationRe
 
 // **************** OVS driver API ********************
 
-// CreatePort Create an internal port in OVS
-func (ovsd *OvsBridgeDriver) CreatePort(intfName, contNetnsPath, contIfaceName, ovnPortName string, ofportRequest uint, vlanTag uint, trunks []uint, portType string, intfType string, contPodUid string) error {
-	intfUUID, intfOp, err := createInterfaceOperation(intfName, ofportRequest, ovnPortName, intfType)
+// CreatePort Create an internal port in OVS. externalIDs are recorded by
+// the port along with the ones identifying the container interface.
+// ovnPortVer, if any, is the version of the OVN logical port the interface
+// is bound to.
+func (ovsd *OvsBridgeDriver) CreatePort(intfName, contNetnsPath, contIfaceName, ovnPortName, ovnPortVer string, ofportRequest uint, vlanTag uint, trunks []uint, portType string, qinq PortQinQ, qos PortQoS, intfType string, intfOptions map[string]string, contPodUid string, contContainerID string, netName string, externalIDs map[string]string) error {
+	intf := newInterface(intfName, ofportRequest, ovnPortName, ovnPortVer, intfType, intfOptions, qos)
+	port := newPort(intfName, contNetnsPath, contIfaceName, vlanTag, trunks, portType, qinq, intf.UUID, contPodUid, contContainerID, netName, externalIDs)
+
+	// Egress shaping needs a QoS row with a queue, which are inserted in the
+	// same transaction and referenced from the port
+	models := []model.Model{intf}
+	if qos.EgressRate != 0 {
+		qosRow, queue := newPortQoS(qos)
+		port.QoS = &qosRow.UUID
+		models = append(models, queue, qosRow)
+	}
+	models = append(models, port)
+
+	operations, err := ovsd.ovsClient.Create(models...)
 	if err != nil {
 		return err
 	}
 
-	portUUID, portOp, err := createPortOperation(intfName, contNetnsPath, contIfaceName, vlanTag, trunks, portType, intfUUID, contPodUid)
+	bridge := &Bridge{Name: ovsd.OvsBridgeName}
+	mutateOps, err := ovsd.ovsClient.Where(bridge).Mutate(bridge, model.Mutation{
+		Field:   &bridge.Ports,
+		Mutator: ovsdb.MutateOperationInsert,
+		Value:   []string{port.UUID},
+	})
 	if err != nil {
 		return err
 	}
 
-	mutateOp := attachPortOperation(portUUID, ovsd.OvsBridgeName)
-
 	// Perform OVS transaction
-	operations := []ovsdb.Operation{*intfOp, *portOp, *mutateOp}
-
-	_, err = ovsd.ovsdbTransact(operations)
+	_, err = ovsd.ovsdbTransact(append(operations, mutateOps...))
 	return err
 }
 
 // DeletePort Delete a port from OVS
 func (ovsd *OvsBridgeDriver) DeletePort(intfName string) error {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, intfName)
-	row, err := ovsd.findByCondition("Port", condition, nil)
-	if err != nil {
+	port := &Port{Name: intfName}
+	if err := ovsd.get(portTable, port); err != nil {
 		return err
 	}
 
-	externalIDs, err := getExternalIDs(row)
-	if err != nil {
-		return fmt.Errorf("get external ids: %v", err)
-	}
-	if externalIDs["owner"] != ovsPortOwner {
+	if port.ExternalIDs["owner"] != ovsPortOwner {
 		return fmt.Errorf("port not created by ovs-cni")
 	}
 
-	// We make a select transaction using the interface name
-	// Then get the Port UUID from it
-	portUUID := row["_uuid"].(ovsdb.UUID)
-
-	intfOp := deleteInterfaceOperation(intfName)
+	intfOps, err := ovsd.ovsClient.Where(&Interface{Name: intfName}).Delete()
+	if err != nil {
+		return err
+	}
 
-	portOp := deletePortOperation(intfName)
+	portOps, err := ovsd.ovsClient.Where(port).Delete()
+	if err != nil {
+		return err
+	}
 
-	mutateOp := detachPortOperation(portUUID, ovsd.OvsBridgeName)
+	bridge := &Bridge{Name: ovsd.OvsBridgeName}
+	mutateOps, err := ovsd.ovsClient.Where(bridge).Mutate(bridge, model.Mutation{
+		Field:   &bridge.Ports,
+		Mutator: ovsdb.MutateOperationDelete,
+		Value:   []string{port.UUID},
+	})
+	if err != nil {
+		return err
+	}
 
 	// Perform OVS transaction
-	operations := []ovsdb.Operation{*intfOp, *portOp, *mutateOp}
+	operations := append(append(intfOps, portOps...), mutateOps...)
+
+	// QoS and Queue are root tables, rows created for the port are not
+	// garbage collected by ovsdb-server and have to be removed explicitly
+	qosOps, err := ovsd.deletePortQoSOperations(port)
+	if err != nil {
+		return err
+	}
+	operations = append(operations, qosOps...)
 
 	_, err = ovsd.ovsdbTransact(operations)
 	return err
 }
 
-func getExternalIDs(row map[string]interface{}) (map[string]string, error) {
-	rowVal, ok := row["external_ids"]
-	if !ok {
-		return nil, fmt.Errorf("row does not contain external_ids")
+// deletePortQoSOperations returns the operations removing the QoS and Queue
+// rows referenced by the given port, if they were created by ovs-cni
+func (ovsd *OvsDriver) deletePortQoSOperations(port *Port) ([]ovsdb.Operation, error) {
+	qos, err := ovsd.findPortQoS(port)
+	if err != nil || qos == nil {
+		return nil, err
 	}
 
-	rowValOvsMap, ok := rowVal.(ovsdb.OvsMap)
-	if !ok {
-		return nil, fmt.Errorf("not a OvsMap: %T: %v", rowVal, rowVal)
+	if qos.ExternalIDs["owner"] != ovsPortOwner {
+		return nil, nil
 	}
 
-	extIDs := make(map[string]string, len(rowValOvsMap.GoMap))
-	for key, value := range rowValOvsMap.GoMap {
-		extIDs[key.(string)] = value.(string)
+	operations, err := ovsd.ovsClient.Where(qos).Delete()
+	if err != nil {
+		return nil, err
 	}
-	return extIDs, nil
+	for _, queueUUID := range qos.Queues {
+		queueOps, err := ovsd.ovsClient.Where(&Queue{UUID: queueUUID}).Delete()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, queueOps...)
+	}
+	return operations, nil
 }
 
-// BridgeList returns available ovs bridge names
-func (ovsd *OvsDriver) BridgeList() ([]string, error) {
-	selectOp := []ovsdb.Operation{{
-		Op:      "select",
-		Table:   "Bridge",
-		Columns: []string{"name"},
-	}}
-
-	transactionResult, err := ovsd.ovsdbTransact(selectOp)
-	if err != nil {
-		return nil, err
+// findPortQoS returns the QoS referenced by the given port, nil if the port
+// has no QoS or one not created by ovs-cni, which isn't monitored
+func (ovsd *OvsDriver) findPortQoS(port *Port) (*QoS, error) {
+	if port.QoS == nil {
+		return nil, nil
 	}
 
-	if len(transactionResult) != 1 {
-		return nil, fmt.Errorf("unknow error")
+	qos := &QoS{UUID: *port.QoS}
+	if err := ovsd.get(qosTable, qos); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return nil, nil
+		}
+		return nil, err
 	}
+	return qos, nil
+}
 
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return nil, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+// BridgeList returns available ovs bridge names
+func (ovsd *OvsDriver) BridgeList() ([]string, error) {
+	var bridgeRows []Bridge
+	if err := ovsd.ovsClient.List(context.Background(), &bridgeRows); err != nil {
+		return nil, err
 	}
 
 	bridges := []string{}
-	for _, bridge := range operationResult.Rows {
-		bridges = append(bridges, fmt.Sprintf("%v", bridge["name"]))
+	for _, bridge := range bridgeRows {
+		bridges = append(bridges, bridge.Name)
 	}
 
 	return bridges, nil
@@ -262,90 +553,145 @@ func (ovsd *OvsDriver) BridgeList() ([]string, error) {
 
 // GetOFPortOpState retrieves link state of the OF port
 func (ovsd *OvsDriver) GetOFPortOpState(portName string) (string, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)
-	selectOp := []ovsdb.Operation{{
-		Op:      "select",
-		Table:   "Interface",
-		Columns: []string{"link_state"},
-		Where:   []ovsdb.Condition{condition},
-	}}
-
-	transactionResult, err := ovsd.ovsdbTransact(selectOp)
-	if err != nil {
+	intf := &Interface{Name: portName}
+	if err := ovsd.get(interfaceTable, intf); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return "", nil
+		}
 		return "", err
 	}
 
-	if len(transactionResult) != 1 {
-		return "", fmt.Errorf("unknown error")
+	if intf.LinkState == nil {
+		return "", nil
 	}
+	return *intf.LinkState, nil
+}
 
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return "", fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+// GetOFPortNumber retrieves the OpenFlow port number assigned to the
+// interface, 0 is returned when it has not been assigned yet
+func (ovsd *OvsDriver) GetOFPortNumber(portName string) (int, error) {
+	intf := &Interface{Name: portName}
+	if err := ovsd.get(interfaceTable, intf); err != nil {
+		return 0, err
 	}
 
-	if len(operationResult.Rows) != 1 {
-		return "", nil
+	// ofport is an empty set until vswitchd assigns the port number and
+	// -1 when it failed to do so
+	if intf.Ofport == nil || *intf.Ofport < 0 {
+		return 0, nil
+	}
+	return *intf.Ofport, nil
+}
+
+// GetInterfaceOption retrieves a type specific option of the interface, an
+// empty string is returned when the option is not set
+func (ovsd *OvsDriver) GetInterfaceOption(intfName, key string) (string, error) {
+	intf := &Interface{Name: intfName}
+	if err := ovsd.get(interfaceTable, intf); err != nil {
+		return "", err
+	}
+
+	return intf.Options[key], nil
+}
+
+// AddInterfaceExternalIDs adds the external_ids to the interface, the ones
+// already set, e.g. by ovn-controller, are left unchanged
+func (ovsd *OvsDriver) AddInterfaceExternalIDs(intfName string, externalIDs map[string]string) error {
+	intf := &Interface{Name: intfName}
+	operations, err := ovsd.ovsClient.Where(intf).Mutate(intf, model.Mutation{
+		Field:   &intf.ExternalIDs,
+		Mutator: ovsdb.MutateOperationInsert,
+		Value:   externalIDs,
+	})
+	if err != nil {
+		return err
 	}
 
-	return fmt.Sprintf("%v", operationResult.Rows[0]["link_state"]), nil
+	_, err = ovsd.ovsdbTransact(operations)
+	return err
 }
 
 // GetOFPortVlanState retrieves port vlan state of the OF port
 func (ovsd *OvsDriver) GetOFPortVlanState(portName string) (string, *uint, []uint, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)
-	selectOp := []ovsdb.Operation{{
-		Op:      "select",
-		Table:   "Port",
-		Columns: []string{"vlan_mode", "tag", "trunks"},
-		Where:   []ovsdb.Condition{condition},
-	}}
 	var vlanMode = ""
 	var tag *uint = nil
 	var trunks []uint
 
-	transactionResult, err := ovsd.ovsdbTransact(selectOp)
-	if err != nil {
+	port := &Port{Name: portName}
+	if err := ovsd.get(portTable, port); err != nil {
 		return vlanMode, tag, trunks, err
 	}
 
-	if len(transactionResult) != 1 {
-		return vlanMode, tag, trunks, fmt.Errorf("transactionResult length is not one")
+	if port.VlanMode != nil {
+		vlanMode = *port.VlanMode
+	}
+
+	if port.Tag != nil {
+		tagValue := uint(*port.Tag)
+		tag = &tagValue
 	}
 
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return vlanMode, tag, trunks, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+	for _, trunk := range port.Trunks {
+		trunks = append(trunks, uint(trunk))
 	}
+	sort.Slice(trunks, func(i, j int) bool { return trunks[i] < trunks[j] })
 
-	if len(operationResult.Rows) != 1 {
-		return vlanMode, tag, trunks, fmt.Errorf("operationResult.Rows length is not one")
+	return vlanMode, tag, trunks, nil
+}
+
+// GetOFPortQinQState retrieves customer VLANs and ethertype of the OF port
+func (ovsd *OvsDriver) GetOFPortQinQState(portName string) (PortQinQ, error) {
+	qinq := PortQinQ{}
+
+	port := &Port{Name: portName}
+	if err := ovsd.get(portTable, port); err != nil {
+		return qinq, err
 	}
 
-	vlanModeCol := operationResult.Rows[0]["vlan_mode"]
-	switch vlanModeCol.(type) {
-	case string:
-		vlanMode = operationResult.Rows[0]["vlan_mode"].(string)
+	for _, cvlan := range port.Cvlans {
+		qinq.Cvlans = append(qinq.Cvlans, uint(cvlan))
 	}
+	sort.Slice(qinq.Cvlans, func(i, j int) bool { return qinq.Cvlans[i] < qinq.Cvlans[j] })
 
-	tagCol := operationResult.Rows[0]["tag"]
-	switch tagCol.(type) {
-	case float64:
-		tagValue := uint(operationResult.Rows[0]["tag"].(float64))
-		tag = &tagValue
+	qinq.Ethtype = port.OtherConfig["qinq-ethtype"]
+
+	return qinq, nil
+}
+
+// GetOFPortQoSState retrieves bandwidth limits of the OF port
+func (ovsd *OvsDriver) GetOFPortQoSState(portName string) (PortQoS, error) {
+	qos := PortQoS{}
+
+	intf := &Interface{Name: portName}
+	if err := ovsd.get(interfaceTable, intf); err != nil {
+		return qos, err
+	}
+	qos.IngressPolicingRate = uint(intf.IngressPolicingRate)
+	qos.IngressPolicingBurst = uint(intf.IngressPolicingBurst)
+
+	port := &Port{Name: portName}
+	if err := ovsd.get(portTable, port); err != nil {
+		return qos, err
+	}
+	qosRow, err := ovsd.findPortQoS(port)
+	if err != nil || qosRow == nil {
+		return qos, err
 	}
+	qos.EgressRate = getOtherConfigUint(qosRow.OtherConfig, "max-rate")
 
-	trunksCol := operationResult.Rows[0]["trunks"].(ovsdb.OvsSet).GoSet
-	if len(trunksCol) > 0 {
-		for i := range trunksCol {
-			trunks = append(trunks, uint(trunksCol[i].(float64)))
+	for _, queueUUID := range qosRow.Queues {
+		queue := &Queue{UUID: queueUUID}
+		if err := ovsd.get(queueTable, queue); err != nil {
+			return qos, err
 		}
+		qos.EgressBurst = getOtherConfigUint(queue.OtherConfig, "burst")
 	}
 
-	return vlanMode, tag, trunks, nil
+	return qos, nil
 }
 
-// CreateMirror Creates a new mirror to a specific bridge
+// CreateMirror Creates a new mirror to a specific bridge. It succeeds when the
+// mirror was created concurrently by somebody else.
 func (ovsd *OvsBridgeDriver) CreateMirror(bridgeName, mirrorName string) error {
 	mirrorExist, err := ovsd.IsMirrorPresent(mirrorName)
 	if err != nil {
@@ -354,19 +700,33 @@ func (ovsd *OvsBridgeDriver) CreateMirror(bridgeName, mirrorName string) error {
 
 	if !mirrorExist {
 		// Insert a Mirror and add it into Bridges
-		// as 2 operations in a transaction.
-		// The first one returns 'mirrorUUID' to referece the new inserted row
-		// in the second operation.
-		mirrorUUID, mirrorOp, err := createMirrorOperation(mirrorName)
+		// as 2 operations in a transaction, which only applies if no mirror
+		// of that name exists meanwhile.
+		// The first one uses 'newMirror' as named UUID to reference the new
+		// inserted row in the second operation.
+		mirror := newMirror(mirrorName)
+		operations, err := ovsd.ovsClient.Create(mirror)
 		if err != nil {
 			return err
 		}
-		attachMirrorOp := attachMirrorOperation(mirrorUUID, bridgeName)
 
-		// Perform OVS transaction
-		operations := []ovsdb.Operation{*mirrorOp, *attachMirrorOp}
+		bridge := &Bridge{Name: bridgeName}
+		attachMirrorOps, err := ovsd.ovsClient.Where(bridge).Mutate(bridge, model.Mutation{
+			Field:   &bridge.Mirrors,
+			Mutator: ovsdb.MutateOperationInsert,
+			Value:   []string{mirror.UUID},
+		})
+		if err != nil {
+			return err
+		}
 
-		_, err = ovsd.ovsdbTransact(operations)
+		// Perform OVS transaction
+		operations = append([]ovsdb.Operation{waitNoMirrorOperation(mirrorName)}, operations...)
+		_, err = ovsd.ovsdbTransact(append(operations, attachMirrorOps...))
+		if errors.Is(err, errConditionNotMet) {
+			log.Printf("Info: mirror %s was created concurrently", mirrorName)
+			return nil
+		}
 		return err
 	}
 	return nil
@@ -374,251 +734,330 @@ func (ovsd *OvsBridgeDriver) CreateMirror(bridgeName, mirrorName string) error {
 
 // IsMirrorUsed Checks if a mirror of a specific bridge is used (it contains at least a portUUID)
 func (ovsd *OvsBridgeDriver) IsMirrorUsed(bridgeName, mirrorName string) (bool, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	row, err := ovsd.findByCondition("Mirror", condition, nil)
+	mirror, err := ovsd.findMirror(mirrorName)
 	if err != nil {
 		return false, err
 	}
 
-	isEmpty, err := isMirrorEmpty(row)
-	if err != nil {
-		return false, fmt.Errorf("cannot check if mirror %s of bridge %s is empty error: %v", mirrorName, bridgeName, err)
-	}
-
-	return !isEmpty, nil
+	return !isMirrorEmpty(mirror), nil
 }
 
-// DeleteMirror Removes a mirror of a specific bridge
+// DeleteMirror Removes a mirror of a specific bridge. The mirror is only
+// removed if it is still empty when the transaction applies, errMirrorInUse is
+// returned otherwise.
 func (ovsd *OvsBridgeDriver) DeleteMirror(bridgeName, mirrorName string) error {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	row, err := ovsd.findByCondition("Mirror", condition, nil)
+	mirror, err := ovsd.findMirror(mirrorName)
 	if err != nil {
 		return err
 	}
 
-	externalIDs, err := getExternalIDs(row)
-	if err != nil {
-		return fmt.Errorf("get external ids: %v", err)
-	}
-	if externalIDs["owner"] != ovsPortOwner {
+	if mirror.ExternalIDs["owner"] != ovsPortOwner {
 		return fmt.Errorf("mirror not created by ovs-cni")
 	}
 
-	mirrorUUID := row["_uuid"].(ovsdb.UUID)
+	deleteOps, err := ovsd.ovsClient.Where(mirror).Delete()
+	if err != nil {
+		return err
+	}
 
-	deleteOp := deleteMirrorOperation(mirrorName)
-	detachFromBridgeOp := detachMirrorFromBridgeOperation(mirrorUUID, bridgeName)
+	bridge := &Bridge{Name: bridgeName}
+	detachFromBridgeOps, err := ovsd.ovsClient.Where(bridge).Mutate(bridge, model.Mutation{
+		Field:   &bridge.Mirrors,
+		Mutator: ovsdb.MutateOperationDelete,
+		Value:   []string{mirror.UUID},
+	})
+	if err != nil {
+		return err
+	}
 
 	// Perform OVS transaction
-	operations := []ovsdb.Operation{*deleteOp, *detachFromBridgeOp}
-
-	_, err = ovsd.ovsdbTransact(operations)
+	operations := append([]ovsdb.Operation{waitMirrorEmptyOperation(mirror.UUID)}, deleteOps...)
+	_, err = ovsd.ovsdbTransact(append(operations, detachFromBridgeOps...))
+	if errors.Is(err, errConditionNotMet) {
+		return fmt.Errorf("mirror %s: %w", mirrorName, ErrMirrorInUse)
+	}
 	return err
 }
 
 // AttachPortToMirrorProducer Adds a portUUID as 'select_src_port' or 'select_dst_port' to an existing mirror
 // based on ingress and egress values
 func (ovsd *OvsBridgeDriver) AttachPortToMirrorProducer(portUUIDStr, mirrorName string, ingress, egress bool) error {
-	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
-
 	if !ingress && !egress {
 		return errors.New("a mirror producer must have either a ingress or an egress or both")
 	}
 
-	attachPortMirrorOp := attachPortToMirrorProducerOperation(portUUID, mirrorName, ingress, egress)
+	mirror := &Mirror{}
+	var mutations []model.Mutation
+	if ingress {
+		// select_src_port = Ports on which arriving packets are selected for mirroring
+		mutations = append(mutations, model.Mutation{Field: &mirror.SelectSrcPort, Mutator: ovsdb.MutateOperationInsert, Value: []string{portUUIDStr}})
+	}
+	if egress {
+		// select_dst_port = Ports on which departing packets are selected for mirroring
+		mutations = append(mutations, model.Mutation{Field: &mirror.SelectDstPort, Mutator: ovsdb.MutateOperationInsert, Value: []string{portUUIDStr}})
+	}
+
+	operations, err := ovsd.mirrorByName(mirror, mirrorName).Mutate(mirror, mutations...)
+	if err != nil {
+		return err
+	}
 
 	// Perform OVS transaction
-	operations := []ovsdb.Operation{*attachPortMirrorOp}
-
-	_, err := ovsd.ovsdbTransact(operations)
+	_, err = ovsd.ovsdbTransact(operations)
 	return err
 }
 
 // AttachPortToMirrorConsumer Adds portUUID as 'output_port' to an existing mirror
 func (ovsd *OvsBridgeDriver) AttachPortToMirrorConsumer(portUUIDStr, mirrorName string) error {
-	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
+	mirror, err := ovsd.findMirror(mirrorName)
+	if err != nil {
+		return err
+	}
 
-	attachPortMirrorOp := attachPortToMirrorConsumerOperation(portUUID, mirrorName)
+	// output_port = Output port for selected packets, a mirror has at most one
+	if mirror.OutputPort != nil && *mirror.OutputPort != portUUIDStr {
+		return fmt.Errorf("mirror %s already has output port %s", mirrorName, *mirror.OutputPort)
+	}
+	mirror.OutputPort = &portUUIDStr
 
-	// Perform OVS transaction
-	operations := []ovsdb.Operation{*attachPortMirrorOp}
+	operations, err := ovsd.ovsClient.Where(mirror).Update(mirror, &mirror.OutputPort)
+	if err != nil {
+		return err
+	}
 
-	_, err := ovsd.ovsdbTransact(operations)
+	// Perform OVS transaction
+	_, err = ovsd.ovsdbTransact(operations)
 	return err
 }
 
 // DetachPortFromMirrorProducer Removes portUUID as both 'select_src_port' and 'select_dst_port' from an existing mirror
 func (ovsd *OvsBridgeDriver) DetachPortFromMirrorProducer(portUUIDStr, mirrorName string) error {
-	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
-
-	mutateMirrorOp := detachPortFromMirrorOperation(portUUID, mirrorName, MirrorProducer)
+	mirror := &Mirror{}
+	operations, err := ovsd.mirrorByName(mirror, mirrorName).Mutate(mirror,
+		// select_src_port = Ports on which arriving packets are selected for mirroring
+		model.Mutation{Field: &mirror.SelectSrcPort, Mutator: ovsdb.MutateOperationDelete, Value: []string{portUUIDStr}},
+		// select_dst_port = Ports on which departing packets are selected for mirroring
+		model.Mutation{Field: &mirror.SelectDstPort, Mutator: ovsdb.MutateOperationDelete, Value: []string{portUUIDStr}},
+	)
+	if err != nil {
+		return err
+	}
 
 	// Perform OVS transaction
-	operations := []ovsdb.Operation{*mutateMirrorOp}
-
-	_, err := ovsd.ovsdbTransact(operations)
+	_, err = ovsd.ovsdbTransact(operations)
 	return err
 }
 
 // DetachPortFromMirrorConsumer Removes portUUID as 'output_port' from an existing mirror
 func (ovsd *OvsBridgeDriver) DetachPortFromMirrorConsumer(portUUIDStr, mirrorName string) error {
-	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
+	mirror, err := ovsd.findMirror(mirrorName)
+	if err != nil {
+		return err
+	}
 
-	mutateMirrorOp := detachPortFromMirrorOperation(portUUID, mirrorName, MirrorConsumer)
+	// output_port = Output port for selected packets
+	if mirror.OutputPort == nil || *mirror.OutputPort != portUUIDStr {
+		return nil
+	}
+	mirror.OutputPort = nil
 
-	// Perform OVS transaction
-	operations := []ovsdb.Operation{*mutateMirrorOp}
+	operations, err := ovsd.ovsClient.Where(mirror).Update(mirror, &mirror.OutputPort)
+	if err != nil {
+		return err
+	}
 
-	_, err := ovsd.ovsdbTransact(operations)
+	// Perform OVS transaction
+	_, err = ovsd.ovsdbTransact(operations)
 	return err
 }
 
 // GetMirrorUUID Retrieves the UUID of a mirror from its name
 func (ovsd *OvsBridgeDriver) GetMirrorUUID(mirrorName string) (ovsdb.UUID, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	row, err := ovsd.findByCondition("Mirror", condition, nil)
+	mirror, err := ovsd.findMirror(mirrorName)
 	if err != nil {
 		return ovsdb.UUID{}, err
 	}
 
-	// We make a select transaction using the interface name
-	// Then get the Mirror UUID from it
-	mirrorUUID := row["_uuid"].(ovsdb.UUID)
-
-	return mirrorUUID, nil
+	return ovsdb.UUID{GoUUID: mirror.UUID}, nil
 }
 
 // GetPortUUID Retrieves the UUID of a port from its name
 func (ovsd *OvsBridgeDriver) GetPortUUID(portName string) (ovsdb.UUID, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, portName)
-	row, err := ovsd.findByCondition("Port", condition, nil)
-	if err != nil {
+	port := &Port{Name: portName}
+	if err := ovsd.get(portTable, port); err != nil {
 		return ovsdb.UUID{}, err
 	}
 
-	// We make a select transaction using the interface name
-	// Then get the Port UUID from it
-	portUUID := row["_uuid"].(ovsdb.UUID)
-
-	return portUUID, nil
+	return ovsdb.UUID{GoUUID: port.UUID}, nil
 }
 
 // IsMirrorConsumerAlreadyAttached Checks if the 'output_port' column of a mirror consumer contains a port UUID
 func (ovsd *OvsDriver) IsMirrorConsumerAlreadyAttached(mirrorName string) (bool, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	row, err := ovsd.findByCondition("Mirror", condition, nil)
+	mirror, err := ovsd.findMirror(mirrorName)
 	if err != nil {
 		return false, err
 	}
 
-	outputPorts, err := convertToArray(row["output_port"])
-	if err != nil {
-		return false, fmt.Errorf("cannot convert output_port to an array error: %v", err)
-	}
-
-	if len(outputPorts) == 0 {
-		return false, nil
-	}
-	return true, nil
+	return mirror.OutputPort != nil, nil
 }
 
 // CheckMirrorProducerWithPorts Checks the configuration of a mirror producer based on ingress and egress values
 func (ovsd *OvsDriver) CheckMirrorProducerWithPorts(mirrorName string, ingress, egress bool, portUUIDStr string) (bool, error) {
-	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
+	// There is no need to return an error if mirror doesn't exist, because in that case we want to create a new one
+	mirror, err := ovsd.findMirror(mirrorName)
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
 
-	var conditions []ovsdb.Condition = []ovsdb.Condition{}
-	conditionName := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	conditions = append(conditions, conditionName)
-	if ingress {
-		// select_src_port = Ports on which arriving packets are selected for mirroring
-		conditionIngress := ovsdb.NewCondition("select_src_port", ovsdb.ConditionIncludes, portUUID)
-		conditions = append(conditions, conditionIngress)
+	// select_src_port = Ports on which arriving packets are selected for mirroring
+	if ingress && !containsUUID(mirror.SelectSrcPort, portUUIDStr) {
+		return false, nil
 	}
-	if egress {
-		// select_dst_port = Ports on which departing packets are selected for mirroring
-		conditionsEgress := ovsdb.NewCondition("select_dst_port", ovsdb.ConditionIncludes, portUUID)
-		conditions = append(conditions, conditionsEgress)
+	// select_dst_port = Ports on which departing packets are selected for mirroring
+	if egress && !containsUUID(mirror.SelectDstPort, portUUIDStr) {
+		return false, nil
 	}
-
-	// We cannot call findByCondition because we need to pass an array of conditions.
-	// Also, there is no need to return an error if mirror doesn't exist, because in that case we want to create a new one
-	return ovsd.isMirrorExistsByConditions(conditions)
+	return true, nil
 }
 
 // CheckMirrorConsumerWithPorts Checks the configuration of a mirror consumer
 func (ovsd *OvsDriver) CheckMirrorConsumerWithPorts(mirrorName string, portUUIDStr string) (bool, error) {
-	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
-
-	var conditions []ovsdb.Condition = []ovsdb.Condition{}
-	conditionName := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	conditions = append(conditions, conditionName)
+	// There is no need to return an error if mirror doesn't exist, because in that case we want to create a new one
+	mirror, err := ovsd.findMirror(mirrorName)
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
 
 	// output_port = Output port for selected packets
-	conditionOutput := ovsdb.NewCondition("output_port", ovsdb.ConditionEqual, portUUID)
-	conditions = append(conditions, conditionOutput)
-
-	// We cannot call findByCondition because we need to pass an array of conditions.
-	// Also, there is no need to return an error if mirror doesn't exist, because in that case we want to create a new one
-	return ovsd.isMirrorExistsByConditions(conditions)
+	return mirror.OutputPort != nil && *mirror.OutputPort == portUUIDStr, nil
 }
 
 // IsMirrorPresent Checks if the Mirror entry already exists
 func (ovsd *OvsDriver) IsMirrorPresent(mirrorName string) (bool, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	selectOp := []ovsdb.Operation{{
-		Op:      "select",
-		Table:   "Mirror",
-		Where:   []ovsdb.Condition{condition},
-		Columns: []string{"name"},
-	}}
-
-	transactionResult, err := ovsd.ovsdbTransact(selectOp)
-	if err != nil {
+	if _, err := ovsd.findMirror(mirrorName); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return false, nil
+		}
 		return false, err
 	}
 
-	if len(transactionResult) != 1 {
-		return false, fmt.Errorf("unknow error")
-	}
-
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return false, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
-	}
-
-	if len(operationResult.Rows) != 1 {
-		return false, nil
-	}
-
 	return true, nil
 }
 
-// IsBridgePresent Checks if the bridge entry already exists
-func (ovsd *OvsDriver) IsBridgePresent(bridgeName string) (bool, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridgeName)
-	selectOp := []ovsdb.Operation{{
-		Op:      "select",
-		Table:   "Bridge",
-		Where:   []ovsdb.Condition{condition},
-		Columns: []string{"name"},
-	}}
+// CreateBridge creates the bridge with its internal port and, if set, the
+// uplink port, and attaches it to the Open_vSwitch root row in a single
+// transaction. It succeeds when the bridge was created concurrently by
+// somebody else.
+func (ovsd *OvsDriver) CreateBridge(bridgeName string, opts BridgeOptions) error {
+	bridge, models := newBridge(bridgeName, opts)
+	operations, err := ovsd.ovsClient.Create(models...)
+	if err != nil {
+		return err
+	}
 
-	transactionResult, err := ovsd.ovsdbTransact(selectOp)
+	// the Open_vSwitch table has a single root row
+	ovs := &OpenvSwitch{}
+	mutateOps, err := ovsd.ovsClient.WhereCache(func(*OpenvSwitch) bool { return true }).Mutate(ovs, model.Mutation{
+		Field:   &ovs.Bridges,
+		Mutator: ovsdb.MutateOperationInsert,
+		Value:   []string{bridge.UUID},
+	})
 	if err != nil {
-		return false, err
+		return err
+	}
+	if len(mutateOps) == 0 {
+		return fmt.Errorf("failed to create bridge %s: %w in the table %s", bridgeName, errObjectNotFound, ovsTable)
 	}
 
-	if len(transactionResult) != 1 {
-		return false, fmt.Errorf("unknow error")
+	if _, err = ovsd.ovsdbTransact(append(operations, mutateOps...)); err != nil {
+		// bridge name is a table index, a concurrent creation of the same
+		// bridge makes the transaction fail with a constraint violation
+		found, findErr := ovsd.IsBridgePresent(bridgeName)
+		if findErr == nil && found {
+			return nil
+		}
+		return fmt.Errorf("failed to create bridge %s: %v", bridgeName, err)
 	}
 
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return false, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+	return nil
+}
+
+// GetBridgeDatapathType retrieves the datapath type of the bridge, an empty
+// string stands for the default system datapath
+func (ovsd *OvsDriver) GetBridgeDatapathType(bridgeName string) (string, error) {
+	bridge := &Bridge{Name: bridgeName}
+	if err := ovsd.get(bridgeTable, bridge); err != nil {
+		return "", err
 	}
 
-	if len(operationResult.Rows) != 1 {
-		return false, nil
+	return bridge.DatapathType, nil
+}
+
+// GetBridgeUplinkMTUs retrieves the MTU of the uplinks of the bridge, indexed
+// by interface name. Uplinks are the system and dpdk interfaces of the ports
+// not created by ovs-cni, tunnel and patch interfaces have no MTU of their
+// own. The MTU is 0 when vswitchd has not reported it.
+func (ovsd *OvsDriver) GetBridgeUplinkMTUs(bridgeName string) (map[string]int, error) {
+	bridge := &Bridge{Name: bridgeName}
+	if err := ovsd.get(bridgeTable, bridge); err != nil {
+		return nil, err
+	}
+
+	mtus := make(map[string]int)
+	for _, portUUID := range bridge.Ports {
+		port := &Port{UUID: portUUID}
+		if err := ovsd.get(portTable, port); err != nil {
+			return nil, err
+		}
+		if port.ExternalIDs["owner"] == ovsPortOwner {
+			continue
+		}
+
+		for _, intfUUID := range port.Interfaces {
+			intf := &Interface{UUID: intfUUID}
+			if err := ovsd.get(interfaceTable, intf); err != nil {
+				return nil, err
+			}
+			switch intf.Type {
+			case "", "system", "dpdk":
+			default:
+				continue
+			}
+			// mtu is an empty set until vswitchd reports it
+			mtus[intf.Name] = 0
+			if intf.MTU != nil {
+				mtus[intf.Name] = *intf.MTU
+			}
+		}
+	}
+	return mtus, nil
+}
+
+// IsBridgePresent Checks if the bridge entry already exists
+func (ovsd *OvsDriver) IsBridgePresent(bridgeName string) (bool, error) {
+	if err := ovsd.get(bridgeTable, &Bridge{Name: bridgeName}); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// IsPortPresent Checks if a port with the given name exists
+func (ovsd *OvsDriver) IsPortPresent(portName string) (bool, error) {
+	if err := ovsd.get(portTable, &Port{Name: portName}); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return false, nil
+		}
+		return false, err
 	}
 
 	return true, nil
@@ -626,50 +1065,161 @@ func (ovsd *OvsDriver) IsBridgePresent(bridgeName string) (bool, error) {
 
 // FindBridgeByInterface returns name of the bridge that contains provided interface
 func (ovsd *OvsDriver) FindBridgeByInterface(ifaceName string) (string, error) {
-	iface, err := ovsd.findByCondition("Interface",
-		ovsdb.NewCondition("name", ovsdb.ConditionEqual, ifaceName),
-		[]string{"name", "_uuid"})
-	if err != nil {
+	intf := &Interface{Name: ifaceName}
+	if err := ovsd.get(interfaceTable, intf); err != nil {
 		return "", fmt.Errorf("failed to find interface %s: %v", ifaceName, err)
 	}
-	port, err := ovsd.findByCondition("Port",
-		ovsdb.NewCondition("interfaces", ovsdb.ConditionIncludes, iface["_uuid"]),
-		[]string{"name", "_uuid"})
+
+	var ports []Port
+	err := ovsd.ovsClient.WhereCache(func(port *Port) bool {
+		return containsUUID(port.Interfaces, intf.UUID)
+	}).List(context.Background(), &ports)
+	if err == nil && len(ports) != 1 {
+		err = fmt.Errorf("%w in the table %s", errObjectNotFound, portTable)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to find port %s: %v", ifaceName, err)
 	}
-	bridge, err := ovsd.findByCondition("Bridge",
-		ovsdb.NewCondition("ports", ovsdb.ConditionIncludes, port["_uuid"]),
-		[]string{"name"})
+
+	var bridges []Bridge
+	err = ovsd.ovsClient.WhereCache(func(bridge *Bridge) bool {
+		return containsUUID(bridge.Ports, ports[0].UUID)
+	}).List(context.Background(), &bridges)
+	if err == nil && len(bridges) != 1 {
+		err = fmt.Errorf("%w in the table %s", errObjectNotFound, bridgeTable)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to find bridge for %s: %v", ifaceName, err)
 	}
-	return fmt.Sprintf("%v", bridge["name"]), nil
+	return bridges[0].Name, nil
 }
 
-// GetOvsPortForContIface Return ovs port name for an container interface
-func (ovsd *OvsDriver) GetOvsPortForContIface(contIface, contNetnsPath string) (string, bool, error) {
-	searchMap := map[string]string{
-		"contNetns": contNetnsPath,
-		"contIface": contIface,
-		"owner":     ovsPortOwner,
+// GetOvsPortForContIface Return ovs port name for an container interface.
+// portName, when the port is named after the container interface, is looked
+// up first rather than scanning all the ports.
+func (ovsd *OvsDriver) GetOvsPortForContIface(portName, contIface, contNetnsPath string) (string, bool, error) {
+	isContPort := func(port *Port) bool {
+		return port.ExternalIDs["contNetns"] == contNetnsPath &&
+			port.ExternalIDs["contIface"] == contIface &&
+			port.ExternalIDs["owner"] == ovsPortOwner
+	}
+
+	if portName != "" {
+		port := &Port{Name: portName}
+		err := ovsd.get(portTable, port)
+		if err != nil && !errors.Is(err, errObjectNotFound) {
+			return "", false, err
+		}
+		if err == nil && isContPort(port) {
+			return portName, true, nil
+		}
+		// the port may have been named otherwise when it was created
 	}
-	ovsmap, err := ovsdb.NewOvsMap(searchMap)
+
+	var ports []Port
+	err := ovsd.ovsClient.WhereCache(isContPort).List(context.Background(), &ports)
 	if err != nil {
 		return "", false, err
 	}
+	if len(ports) != 1 {
+		return "", false, nil
+	}
+
+	return ports[0].Name, true, nil
+}
 
-	condition := ovsdb.NewCondition("external_ids", ovsdb.ConditionIncludes, ovsmap)
-	colums := []string{"name", "external_ids"}
-	port, err := ovsd.findByCondition("Port", condition, colums)
+// ContainerPort is a port created by ovs-cni for a container interface
+type ContainerPort struct {
+	Bridge      string
+	Name        string
+	ExternalIDs map[string]string
+}
+
+// FindContainerPort returns the port created by ovs-cni for the given
+// interface of the container, nil if there isn't any
+func (ovsd *OvsDriver) FindContainerPort(containerID, contIface string) (*ContainerPort, error) {
+	var ports []Port
+	err := ovsd.ovsClient.WhereCache(func(port *Port) bool {
+		return port.ExternalIDs["contContainerId"] == containerID &&
+			port.ExternalIDs["contIface"] == contIface &&
+			port.ExternalIDs["owner"] == ovsPortOwner
+	}).List(context.Background(), &ports)
+	if err != nil {
+		return nil, err
+	}
+	if len(ports) != 1 {
+		return nil, nil
+	}
+
+	var bridges []Bridge
+	err = ovsd.ovsClient.WhereCache(func(bridge *Bridge) bool {
+		return containsUUID(bridge.Ports, ports[0].UUID)
+	}).List(context.Background(), &bridges)
+	if err != nil {
+		return nil, err
+	}
+	if len(bridges) != 1 {
+		return nil, nil
+	}
+
+	return &ContainerPort{
+		Bridge:      bridges[0].Name,
+		Name:        ports[0].Name,
+		ExternalIDs: ports[0].ExternalIDs,
+	}, nil
+}
+
+// FindInterfaceByOption returns the name of the interface having the given
+// type specific option set to value
+func (ovsd *OvsDriver) FindInterfaceByOption(key, value string) (string, bool, error) {
+	var intfs []Interface
+	err := ovsd.ovsClient.WhereCache(func(intf *Interface) bool {
+		return intf.Options[key] == value
+	}).List(context.Background(), &intfs)
 	if err != nil {
-		if errors.Is(err, errObjectNotFound) {
-			return "", false, nil
-		}
 		return "", false, err
 	}
+	if len(intfs) != 1 {
+		return "", false, nil
+	}
 
-	return fmt.Sprintf("%v", port["name"]), true, nil
+	return intfs[0].Name, true, nil
+}
+
+// FindNetworkPorts returns the external_ids of all ports created by ovs-cni
+// for the given network, indexed by port name
+func (ovsd *OvsDriver) FindNetworkPorts(netName string) (map[string]map[string]string, error) {
+	var portRows []Port
+	err := ovsd.ovsClient.WhereCache(func(port *Port) bool {
+		return port.ExternalIDs["netName"] == netName && port.ExternalIDs["owner"] == ovsPortOwner
+	}).List(context.Background(), &portRows)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[string]map[string]string, len(portRows))
+	for _, port := range portRows {
+		ports[port.Name] = port.ExternalIDs
+	}
+	return ports, nil
+}
+
+// FindLegacyPorts returns the external_ids of the ports created by ovs-cni
+// versions not recording the container ID, indexed by port name
+func (ovsd *OvsDriver) FindLegacyPorts() (map[string]map[string]string, error) {
+	var portRows []Port
+	err := ovsd.ovsClient.WhereCache(func(port *Port) bool {
+		return port.ExternalIDs["contContainerId"] == "" && port.ExternalIDs["owner"] == ovsPortOwner
+	}).List(context.Background(), &portRows)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[string]map[string]string, len(portRows))
+	for _, port := range portRows {
+		ports[port.Name] = port.ExternalIDs
+	}
+	return ports, nil
 }
 
 // CleanEmptyMirrors removes all empty mirrors
@@ -689,421 +1239,494 @@ func (ovsd *OvsBridgeDriver) CleanEmptyMirrors() error {
 	return nil
 }
 
-// FindInterfacesWithError returns the interfaces which are in error state
-func (ovsd *OvsDriver) FindInterfacesWithError() ([]string, error) {
-	selectOp := ovsdb.Operation{
-		Op:      "select",
-		Columns: []string{"name", "error"},
-		Table:   "Interface",
+// ErrorPort is a port created by ovs-cni whose interface is in error state
+type ErrorPort struct {
+	Bridge string
+	Name   string
+	Error  string
+}
+
+// FindOwnedPortsWithError returns the ports created by ovs-cni whose
+// interface is in error state, e.g. because its netdev is gone
+func (ovsd *OvsDriver) FindOwnedPortsWithError() ([]ErrorPort, error) {
+	var intfs []Interface
+	if err := ovsd.ovsClient.WhereCache(hasError).List(context.Background(), &intfs); err != nil {
+		return nil, err
+	}
+	if len(intfs) == 0 {
+		return nil, nil
+	}
+	intfErrors := make(map[string]string, len(intfs))
+	for _, intf := range intfs {
+		intfErrors[intf.UUID] = *intf.Error
 	}
-	transactionResult, err := ovsd.ovsdbTransact([]ovsdb.Operation{selectOp})
+
+	var ports []Port
+	err := ovsd.ovsClient.WhereCache(func(port *Port) bool {
+		if port.ExternalIDs["owner"] != ovsPortOwner {
+			return false
+		}
+		for _, intfUUID := range port.Interfaces {
+			if _, found := intfErrors[intfUUID]; found {
+				return true
+			}
+		}
+		return false
+	}).List(context.Background(), &ports)
 	if err != nil {
 		return nil, err
 	}
-	if len(transactionResult) != 1 {
-		return nil, fmt.Errorf("no transaction result")
+	if len(ports) == 0 {
+		return nil, nil
+	}
+
+	var bridges []Bridge
+	if err := ovsd.ovsClient.List(context.Background(), &bridges); err != nil {
+		return nil, err
 	}
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return nil, errors.New(operationResult.Error)
+	portBridges := make(map[string]string)
+	for _, bridge := range bridges {
+		for _, portUUID := range bridge.Ports {
+			portBridges[portUUID] = bridge.Name
+		}
 	}
 
-	var names []string
-	for _, row := range operationResult.Rows {
-		if !hasError(row) {
+	errorPorts := make([]ErrorPort, 0, len(ports))
+	for _, port := range ports {
+		bridgeName, found := portBridges[port.UUID]
+		if !found {
 			continue
 		}
-		names = append(names, fmt.Sprintf("%v", row["name"]))
-	}
-	if len(names) > 0 {
-		log.Printf("found %d interfaces with error", len(names))
+		errorPort := ErrorPort{Bridge: bridgeName, Name: port.Name}
+		for _, intfUUID := range port.Interfaces {
+			if intfError, found := intfErrors[intfUUID]; found {
+				errorPort.Error = intfError
+				break
+			}
+		}
+		errorPorts = append(errorPorts, errorPort)
 	}
-	return names, nil
+	return errorPorts, nil
 }
 
 // InterfaceHasError checks whether a specific interface is in error state
 func (ovsd *OvsDriver) InterfaceHasError(ifaceName string) (bool, error) {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, ifaceName)
-	row, err := ovsd.findByCondition("Interface", condition, []string{"error"})
-	if err != nil {
+	intf := &Interface{Name: ifaceName}
+	if err := ovsd.get(interfaceTable, intf); err != nil {
 		if errors.Is(err, errObjectNotFound) {
 			return false, nil
 		}
 		return false, err
 	}
-	return hasError(row), nil
+	return hasError(intf), nil
 }
 
-func hasError(row map[string]interface{}) bool {
-	v := row["error"]
-	switch x := v.(type) {
-	case string:
-		return x != ""
-	default:
-		return false
-	}
+func hasError(intf *Interface) bool {
+	return intf.Error != nil && *intf.Error != ""
 }
 
 // ************************ Notification handler for OVS DB changes ****************
 
-// Update yet to be implemented
-func (ovsd *OvsDriver) Update(context interface{}, tableUpdates ovsdb.TableUpdates) {
-}
-
-// Disconnected yet to be implemented
-func (ovsd *OvsDriver) Disconnected(ovsClient client.Client) {
+// WaitInterfaceUp waits for the interface link state to be up and its
+// OpenFlow port number to be assigned, which is returned. The interface
+// error, if any, is reported when ctx expires first.
+func (ovsd *OvsDriver) WaitInterfaceUp(ctx context.Context, intfName string) (int, error) {
+	intf, err := ovsd.waitInterface(ctx, intfName, isInterfaceUp)
+	if err != nil {
+		return 0, err
+	}
+	if !isInterfaceUp(&intf) {
+		return 0, interfaceNotUpError(intfName, intf)
+	}
+	return *intf.Ofport, nil
 }
 
-// Locked yet to be implemented
-func (ovsd *OvsDriver) Locked([]interface{}) {
+func isInterfaceUp(intf *Interface) bool {
+	return intf.LinkState != nil && *intf.LinkState == "up" && hasOfport(intf)
 }
 
-// Stolen yet to be implemented
-func (ovsd *OvsDriver) Stolen([]interface{}) {
+// WaitOFPort waits for the OpenFlow port number of the interface to be
+// assigned, which is returned
+func (ovsd *OvsDriver) WaitOFPort(ctx context.Context, intfName string) (int, error) {
+	intf, err := ovsd.waitInterface(ctx, intfName, hasOfport)
+	if err != nil {
+		return 0, err
+	}
+	if !hasOfport(&intf) {
+		return 0, fmt.Errorf("interface %s has no OpenFlow port number: error=%s", intfName, interfaceError(intf))
+	}
+	return *intf.Ofport, nil
 }
 
-// Echo yet to be implemented
-func (ovsd *OvsDriver) Echo([]interface{}) {
+func hasOfport(intf *Interface) bool {
+	return intf.Ofport != nil && *intf.Ofport > 0
 }
 
-// ************************ Helper functions ********************
-func (ovsd *OvsDriver) findByCondition(table string, condition ovsdb.Condition, columns []string) (map[string]interface{}, error) {
-	selectOp := ovsdb.Operation{
-		Op:    "select",
-		Table: table,
-		Where: []ovsdb.Condition{condition},
-	}
-
-	if columns != nil {
-		selectOp.Columns = columns
-	}
-
-	transactionResult, err := ovsd.ovsdbTransact([]ovsdb.Operation{selectOp})
+// WaitOvnInstalled waits for ovn-controller to report, through the
+// ovn-installed external_id, that it has programmed the flows of the
+// interface
+func (ovsd *OvsDriver) WaitOvnInstalled(ctx context.Context, intfName string) error {
+	intf, err := ovsd.waitInterface(ctx, intfName, isOvnInstalled)
 	if err != nil {
-		return nil, err
-	}
-
-	if len(transactionResult) != 1 {
-		return nil, fmt.Errorf("unknown error")
-	}
-
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return nil, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+		return err
 	}
-
-	if len(operationResult.Rows) != 1 {
-		return nil, fmt.Errorf("%w in the table %s", errObjectNotFound, table)
+	if !isOvnInstalled(&intf) {
+		return fmt.Errorf("interface %s is not installed by ovn-controller: iface-id=%s,error=%s",
+			intfName, intf.ExternalIDs["iface-id"], interfaceError(intf))
 	}
+	return nil
+}
 
-	return operationResult.Rows[0], nil
+func isOvnInstalled(intf *Interface) bool {
+	return intf.ExternalIDs["ovn-installed"] == "true"
 }
 
-// isMirrorExistsByConditions find a mirror by a list conditions.
-// It returns true, only if there is a single row as result.
-func (ovsd *OvsDriver) isMirrorExistsByConditions(conditions []ovsdb.Condition) (bool, error) {
-	selectOp := []ovsdb.Operation{{
-		Op:      "select",
-		Table:   "Mirror",
-		Where:   conditions,
-		Columns: []string{"name"},
-	}}
+// waitInterface waits for the state of the interface to meet the condition
+// or for ctx to expire, and returns the last known state
+func (ovsd *OvsDriver) waitInterface(ctx context.Context, intfName string, condition func(*Interface) bool) (Interface, error) {
+	updates := make(chan Interface, 1)
+	watchers := ovsd.interfaceWatchers
+	watchers.Lock()
+	if watchers.channels[intfName] == nil {
+		watchers.channels[intfName] = make(map[chan Interface]bool)
+	}
+	watchers.channels[intfName][updates] = true
+	watchers.Unlock()
+	defer func() {
+		watchers.Lock()
+		delete(watchers.channels[intfName], updates)
+		if len(watchers.channels[intfName]) == 0 {
+			delete(watchers.channels, intfName)
+		}
+		watchers.Unlock()
+	}()
 
-	transactionResult, err := ovsd.ovsdbTransact(selectOp)
-	if err != nil {
-		return false, err
+	// updates only report the changes made once the watcher is registered,
+	// the interface may be in the expected state already
+	last := Interface{Name: intfName}
+	if err := ovsd.ovsClient.Get(ctx, &last); err != nil && !errors.Is(err, client.ErrNotFound) {
+		return last, fmt.Errorf("failed to get interface %s: %v", intfName, err)
 	}
 
-	if len(transactionResult) != 1 {
-		return false, nil
+	for {
+		if condition(&last) {
+			return last, nil
+		}
+		select {
+		case last = <-updates:
+		case <-ctx.Done():
+			return last, nil
+		}
 	}
+}
 
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return false, fmt.Errorf("%s - %s", operationResult.Error, operationResult.Details)
+func interfaceNotUpError(intfName string, intf Interface) error {
+	linkState, ofport := "unknown", "unassigned"
+	if intf.LinkState != nil {
+		linkState = *intf.LinkState
 	}
-
-	if len(operationResult.Rows) != 1 {
-		return false, nil
+	if hasOfport(&intf) {
+		ofport = strconv.Itoa(*intf.Ofport)
 	}
-
-	return true, nil
+	return fmt.Errorf("interface %s is not up: link_state=%s,ofport=%s,error=%s", intfName, linkState, ofport, interfaceError(intf))
 }
 
-func createInterfaceOperation(intfName string, ofportRequest uint, ovnPortName string, intfType string) (ovsdb.UUID, *ovsdb.Operation, error) {
-	intfUUIDStr := fmt.Sprintf("Intf%s", intfName)
-	intfUUID := ovsdb.UUID{GoUUID: intfUUIDStr}
-
-	intf := make(map[string]interface{})
-	intf["name"] = intfName
-
-	// Configure interface type if not nil
-	if intfType != "" {
-		intf["type"] = intfType
+func interfaceError(intf Interface) string {
+	if intf.Error != nil {
+		return *intf.Error
 	}
+	return "none"
+}
 
-	// Configure interface ID for ovn
-	if ovnPortName != "" {
-		oMap, err := ovsdb.NewOvsMap(map[string]string{"iface-id": ovnPortName})
-		if err != nil {
-			return ovsdb.UUID{}, nil, err
-		}
-		intf["external_ids"] = oMap
-	}
+// handleNotifications registers the driver as the handler of the updates of
+// the monitored tables
+func (ovsd *OvsDriver) handleNotifications() {
+	ovsd.interfaceWatchers = &interfaceWatchers{channels: make(map[string]map[chan Interface]bool)}
+	ovsd.ovsClient.Cache().AddEventHandler(&cache.EventHandlerFuncs{
+		AddFunc: func(table string, row model.Model) {
+			ovsd.Update(table, row)
+		},
+		UpdateFunc: func(table string, _, row model.Model) {
+			ovsd.Update(table, row)
+		},
+	})
+}
 
-	// Requested OpenFlow port number for this interface
-	if ofportRequest != 0 {
-		intf["ofport_request"] = ofportRequest
+// Update passes the new state of a monitored interface to the goroutines
+// waiting for it
+func (ovsd *OvsDriver) Update(table string, row model.Model) {
+	intf, ok := row.(*Interface)
+	if table != interfaceTable || !ok {
+		return
+	}
+
+	ovsd.interfaceWatchers.Lock()
+	defer ovsd.interfaceWatchers.Unlock()
+	for updates := range ovsd.interfaceWatchers.channels[intf.Name] {
+		// only the latest state matters, replace the one not consumed yet
+		select {
+		case <-updates:
+		default:
+		}
+		select {
+		case updates <- *intf:
+		default:
+		}
 	}
+}
 
-	// Add an entry in Interface table
-	intfOp := ovsdb.Operation{
-		Op:       "insert",
-		Table:    "Interface",
-		Row:      intf,
-		UUIDName: intfUUIDStr,
-	}
+// Disconnected yet to be implemented
+func (ovsd *OvsDriver) Disconnected(ovsClient client.Client) {
+}
 
-	return intfUUID, &intfOp, nil
+// Locked yet to be implemented
+func (ovsd *OvsDriver) Locked([]interface{}) {
 }
 
-func createPortOperation(intfName, contNetnsPath, contIfaceName string, vlanTag uint, trunks []uint, portType string, intfUUID ovsdb.UUID, contPodUid string) (ovsdb.UUID, *ovsdb.Operation, error) {
-	portUUIDStr := intfName
-	portUUID := ovsdb.UUID{GoUUID: portUUIDStr}
+// Stolen yet to be implemented
+func (ovsd *OvsDriver) Stolen([]interface{}) {
+}
 
-	port := make(map[string]interface{})
-	port["name"] = intfName
+// Echo yet to be implemented
+func (ovsd *OvsDriver) Echo([]interface{}) {
+}
 
-	port["vlan_mode"] = portType
-	var err error
-	if portType == "access" {
-		port["tag"] = vlanTag
-	} else if len(trunks) > 0 {
-		port["trunks"], err = ovsdb.NewOvsSet(trunks)
-		if err != nil {
-			return ovsdb.UUID{}, nil, err
-		}
-	}
+// ************************ Helper functions ********************
 
-	port["interfaces"], err = ovsdb.NewOvsSet(intfUUID)
-	if err != nil {
-		return ovsdb.UUID{}, nil, err
+// get fills the model with the cached row matching one of its indexes, i.e.
+// the UUID or, for most tables, the name
+func (ovsd *OvsDriver) get(table string, m model.Model) error {
+	err := ovsd.ovsClient.Get(context.Background(), m)
+	if errors.Is(err, client.ErrNotFound) {
+		return fmt.Errorf("%w in the table %s", errObjectNotFound, table)
 	}
+	return err
+}
 
-	oMap, err := ovsdb.NewOvsMap(map[string]string{
-		"contPodUid": contPodUid,
-		"contNetns":  contNetnsPath,
-		"contIface":  contIfaceName,
-		"owner":      ovsPortOwner,
-	})
+// findMirror returns the cached mirror with the given name, mirror names are
+// not indexed
+func (ovsd *OvsDriver) findMirror(mirrorName string) (*Mirror, error) {
+	var mirrors []*Mirror
+	err := ovsd.ovsClient.WhereCache(func(mirror *Mirror) bool {
+		return mirror.Name == mirrorName
+	}).List(context.Background(), &mirrors)
 	if err != nil {
-		return ovsdb.UUID{}, nil, err
+		return nil, err
 	}
-	port["external_ids"] = oMap
 
-	// Add an entry in Port table
-	portOp := ovsdb.Operation{
-		Op:       "insert",
-		Table:    "Port",
-		Row:      port,
-		UUIDName: portUUIDStr,
+	if len(mirrors) != 1 {
+		return nil, fmt.Errorf("%w in the table %s", errObjectNotFound, mirrorTable)
 	}
-
-	return portUUID, &portOp, nil
+	return mirrors[0], nil
 }
 
-func attachPortOperation(portUUID ovsdb.UUID, bridgeName string) *ovsdb.Operation {
-	// mutate the Ports column of the row in the Bridge table
-	mutateSet, _ := ovsdb.NewOvsSet(portUUID)
-	mutation := ovsdb.NewMutation("ports", ovsdb.MutateOperationInsert, mutateSet)
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridgeName)
-	mutateOp := ovsdb.Operation{
-		Op:        "mutate",
-		Table:     "Bridge",
-		Mutations: []ovsdb.Mutation{*mutation},
-		Where:     []ovsdb.Condition{condition},
-	}
-
-	return &mutateOp
+// mirrorByName selects the mirror with the given name in the operations
+// built by the returned API
+func (ovsd *OvsDriver) mirrorByName(mirror *Mirror, mirrorName string) client.ConditionalAPI {
+	return ovsd.ovsClient.WhereAll(mirror, model.Condition{
+		Field:    &mirror.Name,
+		Function: ovsdb.ConditionEqual,
+		Value:    mirrorName,
+	})
 }
 
-func deleteInterfaceOperation(intfName string) *ovsdb.Operation {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, intfName)
-	intfOp := ovsdb.Operation{
-		Op:    "delete",
-		Table: "Interface",
-		Where: []ovsdb.Condition{condition},
+// newInterface returns the Interface row of a port created by ovs-cni
+func newInterface(intfName string, ofportRequest uint, ovnPortName, ovnPortVer string, intfType string, intfOptions map[string]string, qos PortQoS) *Interface {
+	intf := &Interface{
+		UUID: "newInterface",
+		Name: intfName,
+		// Configure interface type if not nil
+		Type: intfType,
+		// Configure type specific options of the interface
+		Options: intfOptions,
 	}
 
-	return &intfOp
-}
-
-func deletePortOperation(intfName string) *ovsdb.Operation {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, intfName)
-	portOp := ovsdb.Operation{
-		Op:    "delete",
-		Table: "Port",
-		Where: []ovsdb.Condition{condition},
+	// Configure interface ID for ovn
+	if ovnPortName != "" {
+		intf.ExternalIDs = map[string]string{"iface-id": ovnPortName}
+		// ovn-controller only binds the interface to the logical port of
+		// the same version, e.g. not to the one of a previous pod
+		if ovnPortVer != "" {
+			intf.ExternalIDs["iface-id-ver"] = ovnPortVer
+		}
 	}
 
-	return &portOp
-}
+	// Requested OpenFlow port number for this interface
+	if ofportRequest != 0 {
+		ofport := int(ofportRequest)
+		intf.OfportRequest = &ofport
+	}
 
-func detachPortOperation(portUUID ovsdb.UUID, bridgeName string) *ovsdb.Operation {
-	// mutate the Ports column of the row in the Bridge table
-	mutateSet, _ := ovsdb.NewOvsSet(portUUID)
-	mutation := ovsdb.NewMutation("ports", ovsdb.MutateOperationDelete, mutateSet)
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridgeName)
-	mutateOp := ovsdb.Operation{
-		Op:        "mutate",
-		Table:     "Bridge",
-		Mutations: []ovsdb.Mutation{*mutation},
-		Where:     []ovsdb.Condition{condition},
+	// Policing of traffic received from this interface
+	if qos.IngressPolicingRate != 0 {
+		intf.IngressPolicingRate = int(qos.IngressPolicingRate)
+		intf.IngressPolicingBurst = int(qos.IngressPolicingBurst)
 	}
 
-	return &mutateOp
+	return intf
 }
 
-func createMirrorOperation(mirrorName string) (ovsdb.UUID, *ovsdb.Operation, error) {
-	// Create an operation 'named-uuid' with a simple string as defined in RFC7047.
-	// Spec states that 'uuid-name is only meaningful within the scope of a single transaction'.
-	// So we use a simple constant string.
-	mirrorUUIDStr := "newMirror"
-	mirrorUUID := ovsdb.UUID{GoUUID: mirrorUUIDStr}
-
-	mirror := make(map[string]interface{})
-	mirror["name"] = mirrorName
-
-	oMap, err := ovsdb.NewOvsMap(map[string]string{
-		"owner": ovsPortOwner,
-	})
-	if err != nil {
-		return ovsdb.UUID{}, nil, err
+// newPort returns the Port row of a port created by ovs-cni
+func newPort(intfName, contNetnsPath, contIfaceName string, vlanTag uint, trunks []uint, portType string, qinq PortQinQ, intfUUID string, contPodUid, contContainerID, netName string, externalIDs map[string]string) *Port {
+	port := &Port{
+		UUID:       "newPort",
+		Name:       intfName,
+		Interfaces: []string{intfUUID},
+		ExternalIDs: map[string]string{
+			"contPodUid":      contPodUid,
+			"contContainerId": contContainerID,
+			"contNetns":       contNetnsPath,
+			"contIface":       contIfaceName,
+			"netName":         netName,
+			"owner":           ovsPortOwner,
+		},
+	}
+	for key, value := range externalIDs {
+		if _, found := port.ExternalIDs[key]; !found {
+			port.ExternalIDs[key] = value
+		}
 	}
-	mirror["external_ids"] = oMap
 
-	// Add an entry in Port table
-	mirrorOp := ovsdb.Operation{
-		Op:       "insert",
-		Table:    "Mirror",
-		Row:      mirror,
-		UUIDName: mirrorUUIDStr,
+	if portType != "" {
+		port.VlanMode = &portType
+	}
+	tag := int(vlanTag)
+	switch portType {
+	case "access":
+		port.Tag = &tag
+	case "native-tagged", "native-untagged":
+		// tag is the native VLAN, trunks the other VLANs allowed on the port
+		port.Tag = &tag
+		port.Trunks = toIntSlice(trunks)
+	case "dot1q-tunnel":
+		// tag is the service VLAN pushed on the customer VLANs
+		port.Tag = &tag
+		port.Cvlans = toIntSlice(qinq.Cvlans)
+		if qinq.Ethtype != "" {
+			port.OtherConfig = map[string]string{"qinq-ethtype": qinq.Ethtype}
+		}
+	default:
+		port.Trunks = toIntSlice(trunks)
 	}
 
-	return mirrorUUID, &mirrorOp, nil
+	return port
 }
 
-func attachPortToMirrorProducerOperation(portUUID ovsdb.UUID, mirrorName string, ingress, egress bool) *ovsdb.Operation {
-	// mutate the Ingress and Egress columns of the row in the Mirror table
-	mutateSet, _ := ovsdb.NewOvsSet(portUUID)
-	var mutations []ovsdb.Mutation = []ovsdb.Mutation{}
-	if ingress {
-		// select_src_port = Ports on which arriving packets are selected for mirroring
-		mutationIngress := ovsdb.NewMutation("select_src_port", ovsdb.MutateOperationInsert, mutateSet)
-		mutations = append(mutations, *mutationIngress)
+// newPortQoS returns the QoS row shaping the traffic sent to a port, along
+// with its single queue
+func newPortQoS(qos PortQoS) (*QoS, *Queue) {
+	owner := map[string]string{"owner": ovsPortOwner}
+
+	queueConfig := map[string]string{"max-rate": strconv.FormatUint(qos.EgressRate, 10)}
+	if qos.EgressBurst != 0 {
+		queueConfig["burst"] = strconv.FormatUint(qos.EgressBurst, 10)
 	}
-	if egress {
-		// select_dst_port = Ports on which departing packets are selected for mirroring
-		mutationEgress := ovsdb.NewMutation("select_dst_port", ovsdb.MutateOperationInsert, mutateSet)
-		mutations = append(mutations, *mutationEgress)
+	queue := &Queue{
+		UUID:        "newQueue",
+		OtherConfig: queueConfig,
+		ExternalIDs: owner,
 	}
 
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	mutateOp := ovsdb.Operation{
-		Op:        "mutate",
-		Table:     "Mirror",
-		Mutations: mutations,
-		Where:     []ovsdb.Condition{condition},
+	qosRow := &QoS{
+		UUID:        "newQoS",
+		Type:        "linux-htb",
+		OtherConfig: map[string]string{"max-rate": strconv.FormatUint(qos.EgressRate, 10)},
+		// queue 0 is the default queue used for all traffic sent to the port
+		Queues:      map[int]string{0: queue.UUID},
+		ExternalIDs: owner,
 	}
 
-	return &mutateOp
+	return qosRow, queue
 }
 
-func attachPortToMirrorConsumerOperation(portUUID ovsdb.UUID, mirrorName string) *ovsdb.Operation {
-	mutateSet, _ := ovsdb.NewOvsSet(portUUID)
-	// output_port = Output port for selected packets
-	mutation := ovsdb.NewMutation("output_port", ovsdb.MutateOperationInsert, mutateSet)
-
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	mutateOp := ovsdb.Operation{
-		Op:        "mutate",
-		Table:     "Mirror",
-		Mutations: []ovsdb.Mutation{*mutation},
-		Where:     []ovsdb.Condition{condition},
+// newBridge returns the Bridge row of a bridge created by ovs-cni, along with
+// all the rows to insert, the bridge being the last one
+func newBridge(bridgeName string, opts BridgeOptions) (*Bridge, []model.Model) {
+	var models []model.Model
+	var portUUIDs []string
+
+	// same as ovs-vsctl add-br, the bridge has an internal port named
+	// after it
+	portNames := []string{bridgeName}
+	if opts.Uplink != "" {
+		portNames = append(portNames, opts.Uplink)
+	}
+	for i, portName := range portNames {
+		intf := &Interface{
+			UUID: fmt.Sprintf("newInterface%d", i),
+			Name: portName,
+		}
+		if portName == bridgeName {
+			intf.Type = "internal"
+		}
+		port := &Port{
+			UUID:       fmt.Sprintf("newPort%d", i),
+			Name:       portName,
+			Interfaces: []string{intf.UUID},
+		}
+		models = append(models, intf, port)
+		portUUIDs = append(portUUIDs, port.UUID)
 	}
 
-	return &mutateOp
-}
-
-func attachMirrorOperation(mirrorUUID ovsdb.UUID, bridgeName string) *ovsdb.Operation {
-	// mutate the Mirrors column of the row in the Bridge table
-	mutateSet, _ := ovsdb.NewOvsSet(mirrorUUID)
-	mutation := ovsdb.NewMutation("mirrors", ovsdb.MutateOperationInsert, mutateSet)
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridgeName)
-	mutateOp := ovsdb.Operation{
-		Op:        "mutate",
-		Table:     "Bridge",
-		Mutations: []ovsdb.Mutation{*mutation},
-		Where:     []ovsdb.Condition{condition},
+	bridge := &Bridge{
+		UUID:         "newBridge",
+		Name:         bridgeName,
+		Ports:        portUUIDs,
+		DatapathType: opts.DatapathType,
+		ExternalIDs:  map[string]string{"owner": ovsPortOwner},
+	}
+	if opts.FailMode != "" {
+		bridge.FailMode = &opts.FailMode
 	}
 
-	return &mutateOp
+	return bridge, append(models, bridge)
 }
 
-func detachPortFromMirrorOperation(portUUID ovsdb.UUID, mirrorName string, mirrorType int) *ovsdb.Operation {
-	// mutate the Ports column of the row in the Bridge table
-	var mutations []ovsdb.Mutation = []ovsdb.Mutation{}
-	switch mirrorType {
-	case MirrorProducer:
-		mutateSet, _ := ovsdb.NewOvsSet(portUUID)
-		// select_src_port = Ports on which arriving packets are selected for mirroring
-		mutationIngress := ovsdb.NewMutation("select_src_port", ovsdb.MutateOperationDelete, mutateSet)
-		// select_dst_port = Ports on which departing packets are selected for mirroring
-		mutationEgress := ovsdb.NewMutation("select_dst_port", ovsdb.MutateOperationDelete, mutateSet)
-		mutations = append(mutations, *mutationIngress, *mutationEgress)
-	case MirrorConsumer:
-		// output_port = Output port for selected packets
-		mutationOutput := ovsdb.NewMutation("output_port", ovsdb.MutateOperationDelete, portUUID)
-		mutations = append(mutations, *mutationOutput)
-	default:
-		log.Printf("skipping detatch mirror operation because mirrorType is unknown for mirror %s", mirrorName)
-	}
-
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	mutateOp := ovsdb.Operation{
-		Op:        "mutate",
-		Table:     "Mirror",
-		Mutations: mutations,
-		Where:     []ovsdb.Condition{condition},
+// waitNoMirrorOperation returns the operation checking, with no delay, that
+// no mirror of the given name exists. The rows of a wait operation can't be
+// empty, so it checks that the mirrors of that name are not exactly one.
+func waitNoMirrorOperation(mirrorName string) ovsdb.Operation {
+	timeout := 0
+	return ovsdb.Operation{
+		Op:      ovsdb.OperationWait,
+		Table:   mirrorTable,
+		Where:   []ovsdb.Condition{ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)},
+		Columns: []string{"name"},
+		Until:   string(ovsdb.WaitConditionNotEqual),
+		Rows:    []ovsdb.Row{{"name": mirrorName}},
+		Timeout: &timeout,
 	}
-
-	return &mutateOp
 }
 
-func deleteMirrorOperation(mirrorName string) *ovsdb.Operation {
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, mirrorName)
-	mirrorOp := ovsdb.Operation{
-		Op:    "delete",
-		Table: "Mirror",
-		Where: []ovsdb.Condition{condition},
+// waitMirrorEmptyOperation returns the operation checking, with no delay,
+// that the mirror has no select_src_port, select_dst_port and output port
+func waitMirrorEmptyOperation(mirrorUUID string) ovsdb.Operation {
+	timeout := 0
+	emptySet := ovsdb.OvsSet{GoSet: []interface{}{}}
+	return ovsdb.Operation{
+		Op:      ovsdb.OperationWait,
+		Table:   mirrorTable,
+		Where:   []ovsdb.Condition{ovsdb.NewCondition("_uuid", ovsdb.ConditionEqual, ovsdb.UUID{GoUUID: mirrorUUID})},
+		Columns: []string{"select_src_port", "select_dst_port", "output_port"},
+		Until:   string(ovsdb.WaitConditionEqual),
+		Rows: []ovsdb.Row{{
+			"select_src_port": emptySet,
+			"select_dst_port": emptySet,
+			"output_port":     emptySet,
+		}},
+		Timeout: &timeout,
 	}
-
-	return &mirrorOp
 }
 
-func detachMirrorFromBridgeOperation(mirrorUUID ovsdb.UUID, bridgeName string) *ovsdb.Operation {
-	// mutate the Ports column of the row in the Bridge table
-	mutateSet, _ := ovsdb.NewOvsSet(mirrorUUID)
-	mutation := ovsdb.NewMutation("mirrors", ovsdb.MutateOperationDelete, mutateSet)
-	condition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, bridgeName)
-	mutateOp := ovsdb.Operation{
-		Op:        "mutate",
-		Table:     "Bridge",
-		Mutations: []ovsdb.Mutation{*mutation},
-		Where:     []ovsdb.Condition{condition},
+// newMirror returns the Mirror row of a mirror created by ovs-cni
+func newMirror(mirrorName string) *Mirror {
+	return &Mirror{
+		// 'named-uuid' as defined in RFC7047, only meaningful within the
+		// scope of a single transaction
+		UUID:        "newMirror",
+		Name:        mirrorName,
+		ExternalIDs: map[string]string{"owner": ovsPortOwner},
 	}
-
-	return &mutateOp
 }
 
 // findEmptyMirrors returns the empty mirrors (no select_src_port, select_dst_port
@@ -1113,64 +1736,19 @@ func detachMirrorFromBridgeOperation(mirrorUUID ovsdb.UUID, bridgeName string) *
 // violations.
 func (ovsd *OvsBridgeDriver) findEmptyMirrors() ([]string, error) {
 	// Get the mirror UUIDs attached to this bridge.
-	bridgeCondition := ovsdb.NewCondition("name", ovsdb.ConditionEqual, ovsd.OvsBridgeName)
-	bridgeRow, err := ovsd.findByCondition("Bridge", bridgeCondition, []string{"mirrors"})
-	if err != nil {
+	bridge := &Bridge{Name: ovsd.OvsBridgeName}
+	if err := ovsd.get(bridgeTable, bridge); err != nil {
 		return nil, fmt.Errorf("failed to get bridge mirrors: %v", err)
 	}
 
-	bridgeMirrorUUIDs, err := convertToArray(bridgeRow["mirrors"])
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert bridge mirrors to array: %v", err)
-	}
-
-	if len(bridgeMirrorUUIDs) == 0 {
-		return nil, nil
-	}
-
-	// Build a set of this bridge's mirror UUIDs for filtering.
-	bridgeMirrorSet := make(map[string]bool, len(bridgeMirrorUUIDs))
-	for _, elem := range bridgeMirrorUUIDs {
-		if u, ok := elem.(ovsdb.UUID); ok {
-			bridgeMirrorSet[u.GoUUID] = true
-		}
-	}
-
-	// Query all mirrors and keep only those belonging to this bridge.
 	var names []string
-
-	selectOp := ovsdb.Operation{
-		Op:      "select",
-		Columns: []string{"_uuid", "name", "output_port", "select_src_port", "select_dst_port"},
-		Table:   "Mirror",
-	}
-	transactionResult, err := ovsd.ovsdbTransact([]ovsdb.Operation{selectOp})
-	if err != nil {
-		return nil, err
-	}
-	if len(transactionResult) != 1 {
-		return nil, fmt.Errorf("no transaction result")
-	}
-	operationResult := transactionResult[0]
-	if operationResult.Error != "" {
-		return nil, errors.New(operationResult.Error)
-	}
-
-	for _, row := range operationResult.Rows {
-		mirrorUUID, ok := row["_uuid"].(ovsdb.UUID)
-		if !ok {
-			continue
-		}
-		if !bridgeMirrorSet[mirrorUUID.GoUUID] {
-			continue
+	for _, mirrorUUID := range bridge.Mirrors {
+		mirror := &Mirror{UUID: mirrorUUID}
+		if err := ovsd.get(mirrorTable, mirror); err != nil {
+			return nil, err
 		}
-
-		isEmpty, err := isMirrorEmpty(row)
-		if err != nil {
-			return nil, fmt.Errorf("cannot convert select_src_port to an array error: %v", err)
-		}
-		if isEmpty {
-			names = append(names, fmt.Sprintf("%v", row["name"]))
+		if isMirrorEmpty(mirror) {
+			names = append(names, mirror.Name)
 		}
 	}
 
@@ -1180,40 +1758,35 @@ func (ovsd *OvsBridgeDriver) findEmptyMirrors() ([]string, error) {
 	return names, nil
 }
 
-// isMirrorEmpty Checks if a mirror db row has both output_port, select_src_port and select_dst_port empty
-func isMirrorEmpty(dbRow map[string]interface{}) (bool, error) {
-	// Workaround to check output_port, select_dst_port and select_src_port consistently, processing all
-	// of them as array of UUIDs.
-	// This is useful because ovn-org/libovsdb:
-	// - when dbRow["column"] is empty in ovsdb, it returns an empty ovsdb.OvsSet
-	// - when dbRow["column"] contains an UUID reference, it returns a ovsdb.UUID (not ovsdb.OvsSet)
-	// - when dbRow["column"] contains multiple UUID references, it returns an ovsdb.OvsSet with the elements
-	selectSrcPorts, err := convertToArray(dbRow["select_src_port"])
-	if err != nil {
-		return false, fmt.Errorf("cannot convert select_src_port to an array error: %v", err)
-	}
-	selectDstPorts, err := convertToArray(dbRow["select_dst_port"])
-	if err != nil {
-		return false, fmt.Errorf("cannot convert select_dst_port to an array error: %v", err)
-	}
-	outputPorts, err := convertToArray(dbRow["output_port"])
+// isMirrorEmpty Checks if a mirror has both output_port, select_src_port and select_dst_port empty
+func isMirrorEmpty(mirror *Mirror) bool {
+	return len(mirror.SelectSrcPort) == 0 && len(mirror.SelectDstPort) == 0 && mirror.OutputPort == nil
+}
+
+// getOtherConfigUint returns the unsigned integer value of a key in an
+// other_config column, 0 if missing or not a number
+func getOtherConfigUint(otherConfig map[string]string, key string) uint64 {
+	n, err := strconv.ParseUint(otherConfig[key], 10, 64)
 	if err != nil {
-		return false, fmt.Errorf("cannot convert output_port to an array error: %v", err)
+		return 0
 	}
-	isEmpty := len(selectSrcPorts) == 0 && len(selectDstPorts) == 0 && len(outputPorts) == 0
-	return isEmpty, nil
+	return n
 }
 
-// utility function to convert an element (UUID or OvsSet) to an array of UUIDs
-func convertToArray(elem interface{}) ([]interface{}, error) {
-	elemType := reflect.TypeOf(elem)
-	if elemType.Kind() == reflect.Struct {
-		if elemType.Name() == "UUID" {
-			return []interface{}{elem}, nil
-		} else if elemType.Name() == "OvsSet" {
-			return elem.(ovsdb.OvsSet).GoSet, nil
+// containsUUID checks if a set of references contains the given UUID
+func containsUUID(uuids []string, uuid string) bool {
+	for _, u := range uuids {
+		if u == uuid {
+			return true
 		}
-		return nil, errors.New("struct with unknown types")
 	}
-	return nil, errors.New("unknown type")
+	return false
+}
+
+func toIntSlice(values []uint) []int {
+	var ints []int
+	for _, v := range values {
+		ints = append(ints, int(v))
+	}
+	return ints
 }
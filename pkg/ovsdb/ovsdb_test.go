@@ -1,28 +1,234 @@
 package ovsdb
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
 
 var _ = Describe("hasError", func() {
 	It("should return false for empty error string", func() {
-		row := map[string]interface{}{"error": ""}
-		Expect(hasError(row)).To(BeFalse())
+		intfError := ""
+		Expect(hasError(&Interface{Error: &intfError})).To(BeFalse())
 	})
 
 	It("should return true for non-empty error string", func() {
-		row := map[string]interface{}{"error": "could not open network device eth0 (No such device)"}
-		Expect(hasError(row)).To(BeTrue())
+		intfError := "could not open network device eth0 (No such device)"
+		Expect(hasError(&Interface{Error: &intfError})).To(BeTrue())
+	})
+
+	It("should return false when error is not set", func() {
+		Expect(hasError(&Interface{Name: "test"})).To(BeFalse())
+	})
+})
+
+var _ = Describe("getOtherConfigUint", func() {
+	It("should return the numeric value of the key", func() {
+		Expect(getOtherConfigUint(map[string]string{"max-rate": "1000000"}, "max-rate")).To(Equal(uint64(1000000)))
+	})
+
+	It("should return 0 when the key is missing", func() {
+		Expect(getOtherConfigUint(map[string]string{}, "burst")).To(BeZero())
+	})
+
+	It("should return 0 for a non numeric value", func() {
+		Expect(getOtherConfigUint(map[string]string{"burst": "fast"}, "burst")).To(BeZero())
+	})
+})
+
+var _ = Describe("isMirrorEmpty", func() {
+	It("should return true for a mirror without ports", func() {
+		Expect(isMirrorEmpty(&Mirror{Name: "mirror1"})).To(BeTrue())
+	})
+
+	It("should return false for a mirror with an output port", func() {
+		portUUID := "b2c0b7e6-9c7f-4b0a-a3a7-0d5bd7b1d7a5"
+		Expect(isMirrorEmpty(&Mirror{Name: "mirror1", OutputPort: &portUUID})).To(BeFalse())
+	})
+
+	It("should return false for a mirror with selected ports", func() {
+		Expect(isMirrorEmpty(&Mirror{Name: "mirror1", SelectDstPort: []string{"b2c0b7e6-9c7f-4b0a-a3a7-0d5bd7b1d7a5"}})).To(BeFalse())
+	})
+})
+
+var _ = Describe("newBridge", func() {
+	It("should create the bridge with its internal port", func() {
+		bridge, models := newBridge("br1", BridgeOptions{})
+		Expect(models).To(HaveLen(3))
+		Expect(models[0].(*Interface).Type).To(Equal("internal"))
+		Expect(models[1].(*Port).Interfaces).To(ConsistOf(models[0].(*Interface).UUID))
+		Expect(models[2]).To(BeIdenticalTo(bridge))
+		Expect(bridge.FailMode).To(BeNil())
+		Expect(bridge.Ports).To(ConsistOf(models[1].(*Port).UUID))
+	})
+
+	It("should add the uplink port and bridge options", func() {
+		bridge, models := newBridge("br1", BridgeOptions{Uplink: "eth1", DatapathType: "netdev", FailMode: "secure"})
+		Expect(models).To(HaveLen(5))
+		Expect(models[2].(*Interface).Name).To(Equal("eth1"))
+		Expect(models[2].(*Interface).Type).To(BeEmpty())
+		Expect(models[3].(*Port).Name).To(Equal("eth1"))
+		Expect(bridge.DatapathType).To(Equal("netdev"))
+		Expect(*bridge.FailMode).To(Equal("secure"))
+		Expect(bridge.Ports).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("newInterface", func() {
+	It("should not set options by default", func() {
+		intf := newInterface("port1", 0, "", "", "", nil, PortQoS{})
+		Expect(intf.Options).To(BeEmpty())
+		Expect(intf.Type).To(BeEmpty())
+		Expect(intf.OfportRequest).To(BeNil())
 	})
 
-	It("should return false when error key is missing", func() {
-		row := map[string]interface{}{"name": "test"}
-		Expect(hasError(row)).To(BeFalse())
+	It("should set the interface type and options", func() {
+		intf := newInterface("port1", 0, "", "", "dpdkvhostuserclient",
+			map[string]string{"vhost-server-path": "/var/run/vhu/sock"}, PortQoS{})
+		Expect(intf.Type).To(Equal("dpdkvhostuserclient"))
+		Expect(intf.Options).To(HaveKeyWithValue("vhost-server-path", "/var/run/vhu/sock"))
+	})
+
+	It("should bind the interface to the version of the OVN port", func() {
+		intf := newInterface("port1", 0, "lsp1", "pod-uid", "", nil, PortQoS{})
+		Expect(intf.ExternalIDs).To(Equal(map[string]string{"iface-id": "lsp1", "iface-id-ver": "pod-uid"}))
+	})
+
+	It("should not set the version of the OVN port without the port", func() {
+		intf := newInterface("port1", 0, "", "pod-uid", "", nil, PortQoS{})
+		Expect(intf.ExternalIDs).To(BeEmpty())
+	})
+})
+
+var _ = Describe("newPort", func() {
+	It("should set the tag and trunks of a native VLAN port", func() {
+		port := newPort("port1", "/var/run/netns/ns1", "eth1", 100, []uint{200, 201}, "native-untagged", PortQinQ{}, "newInterface", "", "", "", nil)
+		Expect(*port.VlanMode).To(Equal("native-untagged"))
+		Expect(*port.Tag).To(Equal(100))
+		Expect(port.Trunks).To(Equal([]int{200, 201}))
+		Expect(port.ExternalIDs).To(HaveKeyWithValue("owner", ovsPortOwner))
+	})
+
+	It("should set the customer VLANs of a QinQ port", func() {
+		port := newPort("port1", "/var/run/netns/ns1", "eth1", 100, nil, "dot1q-tunnel", PortQinQ{Cvlans: []uint{10}, Ethtype: "802.1q"}, "newInterface", "", "", "", nil)
+		Expect(port.Cvlans).To(Equal([]int{10}))
+		Expect(port.OtherConfig).To(HaveKeyWithValue("qinq-ethtype", "802.1q"))
+		Expect(port.Trunks).To(BeEmpty())
+	})
+
+	It("should record the given external_ids next to the container ones", func() {
+		port := newPort("port1", "/var/run/netns/ns1", "eth1", 0, nil, "", PortQinQ{}, "newInterface", "", "cid", "", map[string]string{
+			"bridge":          "br1",
+			"contContainerId": "other",
+		})
+		Expect(port.ExternalIDs).To(HaveKeyWithValue("bridge", "br1"))
+		Expect(port.ExternalIDs).To(HaveKeyWithValue("contContainerId", "cid"))
+		Expect(port.ExternalIDs).To(HaveKeyWithValue("owner", ovsPortOwner))
+	})
+})
+
+var _ = Describe("interface monitor", func() {
+	It("should pass the latest state of a watched interface", func() {
+		updates := make(chan Interface, 1)
+		ovsd := &OvsDriver{interfaceWatchers: &interfaceWatchers{channels: map[string]map[chan Interface]bool{"port1": {updates: true}}}}
+		down, up, ofport := "down", "up", 3
+
+		ovsd.Update(interfaceTable, &Interface{Name: "port2", LinkState: &up})
+		Expect(updates).To(BeEmpty())
+
+		ovsd.Update(interfaceTable, &Interface{Name: "port1", LinkState: &down})
+		ovsd.Update(interfaceTable, &Interface{Name: "port1", LinkState: &up, Ofport: &ofport})
+		Expect(updates).To(HaveLen(1))
+		intf := <-updates
+		Expect(*intf.LinkState).To(Equal("up"))
+		Expect(*intf.Ofport).To(Equal(3))
+	})
+
+	It("should pass the state to every goroutine waiting for the interface", func() {
+		first, second := make(chan Interface, 1), make(chan Interface, 1)
+		ovsd := &OvsDriver{interfaceWatchers: &interfaceWatchers{channels: map[string]map[chan Interface]bool{"port1": {first: true, second: true}}}}
+		ofport := 3
+
+		ovsd.Update(interfaceTable, &Interface{Name: "port1", Ofport: &ofport})
+		Expect(first).To(HaveLen(1))
+		Expect(second).To(HaveLen(1))
+		Expect(hasOfport(&Interface{Name: "port1"})).To(BeFalse())
+		intf := <-second
+		Expect(hasOfport(&intf)).To(BeTrue())
+	})
+
+	It("should report the interface error", func() {
+		down, intfError := "down", "could not open network device port1 (No such device)"
+		err := interfaceNotUpError("port1", Interface{Name: "port1", LinkState: &down, Error: &intfError})
+		Expect(err.Error()).To(ContainSubstring("link_state=down"))
+		Expect(err.Error()).To(ContainSubstring("ofport=unassigned"))
+		Expect(err.Error()).To(ContainSubstring(intfError))
+	})
+
+	It("should tell when ovn-controller has installed the interface", func() {
+		Expect(isOvnInstalled(&Interface{Name: "port1"})).To(BeFalse())
+		Expect(isOvnInstalled(&Interface{Name: "port1", ExternalIDs: map[string]string{"iface-id": "lsp1"}})).To(BeFalse())
+		Expect(isOvnInstalled(&Interface{Name: "port1", ExternalIDs: map[string]string{"iface-id": "lsp1", "ovn-installed": "true"}})).To(BeTrue())
+	})
+})
+
+var _ = Describe("NewTLSConfig", func() {
+	It("should require the CA certificate, the certificate and the key", func() {
+		_, err := NewTLSConfig(types.SSLConfig{CACert: "/etc/openvswitch/cacert.pem", Cert: "/etc/openvswitch/cert.pem"})
+		Expect(err).To(MatchError(ContainSubstring("requires a CA certificate, a certificate and a private key")))
+	})
+
+	It("should fail when the certificate files do not exist", func() {
+		_, err := NewTLSConfig(types.SSLConfig{CACert: "/nonexistent/cacert.pem", Cert: "/nonexistent/cert.pem", Key: "/nonexistent/key.pem"})
+		Expect(err).To(MatchError(ContainSubstring("failed to load certificate /nonexistent/cert.pem")))
+	})
+
+	It("should reject a server without certificate", func() {
+		Expect(verifyServerCertificate(nil, x509.NewCertPool())).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("NewOvsDriver", func() {
+	It("should give up connecting once the connect timeout expires", func() {
+		config := types.OvsdbConfig{SocketFile: "unix:/nonexistent/db.sock", ConnectTimeout: 500}
+		start := time.Now()
+		_, err := NewOvsDriver(context.Background(), config)
+		Expect(err).To(MatchError(ContainSubstring("failed to connect to ovsdb socket unix:/nonexistent/db.sock")))
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+	})
+})
+
+var _ = Describe("durationOrDefault", func() {
+	It("should convert milliseconds", func() {
+		Expect(durationOrDefault(1500, time.Second)).To(Equal(1500 * time.Millisecond))
+	})
+
+	It("should return the default when unset", func() {
+		Expect(durationOrDefault(0, time.Second)).To(Equal(time.Second))
+	})
+})
+
+var _ = Describe("mirror wait operations", func() {
+	It("should fail the creation of a mirror which exists", func() {
+		op, err := json.Marshal(waitNoMirrorOperation("mirror1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(op).To(MatchJSON(`{"op": "wait", "table": "Mirror", "timeout": 0,
+			"where": [["name", "==", "mirror1"]], "columns": ["name"],
+			"until": "!=", "rows": [{"name": "mirror1"}]}`))
 	})
 
-	It("should return false for non-string error value", func() {
-		row := map[string]interface{}{"error": 42}
-		Expect(hasError(row)).To(BeFalse())
+	It("should fail the deletion of a mirror which is used", func() {
+		op, err := json.Marshal(waitMirrorEmptyOperation("8b9c2e8a-6f3a-4bd1-9f5e-2b7a1c3d4e5f"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(op).To(MatchJSON(`{"op": "wait", "table": "Mirror", "timeout": 0,
+			"where": [["_uuid", "==", ["uuid", "8b9c2e8a-6f3a-4bd1-9f5e-2b7a1c3d4e5f"]]],
+			"columns": ["select_src_port", "select_dst_port", "output_port"],
+			"until": "==", "rows": [{"select_src_port": ["set", []], "select_dst_port": ["set", []], "output_port": ["set", []]}]}`))
 	})
 })
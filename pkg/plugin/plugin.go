@@ -21,18 +21,37 @@
 package plugin
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"runtime"
 
+	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/ipam"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/common"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/deviceinfo"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/internalport"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/vdpa"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/veth"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/vhostuser"
+)
+
+// Error codes returned by STATUS, as defined by the CNI spec 1.1
+// (https://github.com/containernetworking/cni/blob/main/SPEC.md#error).
+// The vendored github.com/containernetworking/cni/pkg/types (v1.3.0) only
+// declares the codes below 50, this one is to be replaced with the library
+// constant once it declares it.
+const (
+	// ErrPluginNotAvailable means the plugin cannot service ADD requests.
+	ErrPluginNotAvailable uint = 50
 )
 
 func init() {
@@ -50,6 +69,7 @@ func logCall(command string, args *skel.CmdArgs) {
 // CmdAdd add handler for attaching container into network
 func CmdAdd(args *skel.CmdArgs) error {
 	logCall("ADD", args)
+	ctx := context.Background()
 
 	netconf, err := config.LoadConf(args.StdinData)
 	if err != nil {
@@ -62,31 +82,44 @@ func CmdAdd(args *skel.CmdArgs) error {
 	}
 
 	if vdpa.IsVdpa(deviceInfo) {
-		return vdpa.CmdAdd(args, netconf)
+		return vdpa.CmdAdd(ctx, args, netconf)
+	}
+
+	if internalport.IsInternalPort(netconf) {
+		return internalport.CmdAdd(ctx, args, netconf)
+	}
+
+	if vhostuser.IsVhostUser(netconf) {
+		return vhostuser.CmdAdd(ctx, args, netconf)
 	}
 
 	if !common.IsOvsHardwareOffloadEnabled(netconf.DeviceID) {
-		return veth.CmdAdd(args, netconf)
+		return veth.CmdAdd(ctx, args, netconf)
 	}
 
-	return sriov.CmdAdd(args, netconf)
+	return sriov.CmdAdd(ctx, args, netconf)
 }
 
 // CmdDel remove handler for deleting container from network
 func CmdDel(args *skel.CmdArgs) error {
 	logCall("DEL", args)
+	ctx := context.Background()
 
 	cRef := config.GetCRef(args.ContainerID, args.IfName)
 	cache, err := config.LoadConfFromCache(cRef)
 	if err != nil {
-		// If cmdDel() fails, cached netconf is cleaned up by
-		// the followed defer call. However, subsequence calls
-		// of cmdDel() from kubelet fail in a dead loop due to
-		// cached netconf doesn't exist.
-		// Return nil when loadConfFromCache fails since the rest
-		// of cmdDel() code relies on netconf as input argument
-		// and there is no meaning to continue.
-		return nil
+		log.Printf("Warning: failed to load cached netconf, recovering it from ovsdb: %v", err)
+		cache = recoverCachedNetConf(ctx, args)
+		if cache == nil {
+			// If cmdDel() fails, cached netconf is cleaned up by
+			// the followed defer call. However, subsequence calls
+			// of cmdDel() from kubelet fail in a dead loop due to
+			// cached netconf doesn't exist.
+			// Return nil when the netconf can't be recovered since
+			// the rest of cmdDel() code relies on netconf as input
+			// argument and there is no meaning to continue.
+			return nil
+		}
 	}
 
 	defer func() {
@@ -94,26 +127,67 @@ func CmdDel(args *skel.CmdArgs) error {
 			if err := utils.CleanCache(cRef); err != nil {
 				log.Printf("Failed cleaning up cache: %v", err)
 			}
+			devInfoPath := deviceinfo.DeviceInfoPath(cache.Netconf.Name, cRef)
+			if err := deviceinfo.CleanDeviceInfo(devInfoPath); err != nil {
+				log.Printf("Failed cleaning up device info: %v", err)
+			}
 		}
 	}()
 
 	if vdpa.CachedDeviceIsVdpa(cache) {
-		err = vdpa.CmdDel(args, cache)
+		err = vdpa.CmdDel(ctx, args, cache)
+		return err
+	}
+
+	if internalport.IsInternalPort(cache.Netconf) {
+		err = internalport.CmdDel(ctx, args, cache)
+		return err
+	}
+
+	if vhostuser.IsVhostUser(cache.Netconf) {
+		err = vhostuser.CmdDel(ctx, args, cache)
 		return err
 	}
 
 	if !common.IsOvsHardwareOffloadEnabled(cache.Netconf.DeviceID) {
-		err = veth.CmdDel(args, cache)
+		err = veth.CmdDel(ctx, args, cache)
 		return err
 	}
 
-	err = sriov.CmdDel(args, cache)
+	err = sriov.CmdDel(ctx, args, cache)
 	return err
 }
 
+// recoverCachedNetConf rebuilds the cached netconf of an attachment whose
+// cache is missing or unreadable from the DEL configuration and the
+// external_ids of its ovsdb port. It returns nil if the attachment can't be
+// recovered.
+func recoverCachedNetConf(ctx context.Context, args *skel.CmdArgs) *types.CachedNetConf {
+	netconf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		log.Printf("Failed to recover cached netconf: %v", err)
+		return nil
+	}
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
+	if err != nil {
+		log.Printf("Failed to recover cached netconf: %v", err)
+		return nil
+	}
+	defer ovsDriver.Close()
+
+	cache, err := common.RecoverCachedNetConf(ovsDriver, args, netconf)
+	if err != nil {
+		log.Printf("Failed to recover cached netconf: %v", err)
+		return nil
+	}
+	return cache
+}
+
 // CmdCheck check handler to make sure networking is as expected.
 func CmdCheck(args *skel.CmdArgs) error {
 	logCall("CHECK", args)
+	ctx := context.Background()
 
 	netconf, err := config.LoadConf(args.StdinData)
 	if err != nil {
@@ -126,12 +200,129 @@ func CmdCheck(args *skel.CmdArgs) error {
 	}
 
 	if vdpa.IsVdpa(deviceInfo) {
-		return vdpa.CmdCheck(args, netconf)
+		return vdpa.CmdCheck(ctx, args, netconf)
+	}
+
+	if internalport.IsInternalPort(netconf) {
+		return internalport.CmdCheck(ctx, args, netconf)
+	}
+
+	if vhostuser.IsVhostUser(netconf) {
+		return vhostuser.CmdCheck(ctx, args, netconf)
 	}
 
 	if !common.IsOvsHardwareOffloadEnabled(netconf.DeviceID) {
-		return veth.CmdCheck(args, netconf)
+		return veth.CmdCheck(ctx, args, netconf)
 	}
 
-	return sriov.CmdCheck(args, netconf)
+	return sriov.CmdCheck(ctx, args, netconf)
+}
+
+// CmdStatus status handler reporting whether the plugin is ready to service
+// ADD requests, i.e. ovsdb is reachable and the configured bridge exists.
+func CmdStatus(args *skel.CmdArgs) error {
+	logCall("STATUS", args)
+	ctx := context.Background()
+
+	netconf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
+	if err != nil {
+		return cnitypes.NewError(ErrPluginNotAvailable, "ovsdb is not reachable", err.Error())
+	}
+	defer ovsDriver.Close()
+
+	// bridge name may be omitted when it is resolved per attachment
+	// (ovnPort or deviceID), nothing more to check in that case. A missing
+	// bridge is created by ADD when createBridge is set.
+	if netconf.BrName != "" && !netconf.CreateBridge {
+		found, err := ovsDriver.IsBridgePresent(netconf.BrName)
+		if err != nil {
+			return cnitypes.NewError(ErrPluginNotAvailable, "failed to look up bridge", err.Error())
+		}
+		if !found {
+			return cnitypes.NewError(ErrPluginNotAvailable, fmt.Sprintf("bridge %s is not found in OVS", netconf.BrName), "")
+		}
+	}
+
+	if netconf.IPAM.Type != "" {
+		if err := ipam.ExecStatus(netconf.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CmdGC garbage collection handler removing ports and cached configuration
+// of attachments which are not in the list of valid attachments provided by
+// the runtime.
+func CmdGC(args *skel.CmdArgs) error {
+	logCall("GC", args)
+	ctx := context.Background()
+
+	netconf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	validAttachments := make(map[string]bool, len(netconf.ValidAttachments))
+	for _, attachment := range netconf.ValidAttachments {
+		validAttachments[config.GetCRef(attachment.ContainerID, attachment.IfName)] = true
+	}
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsDriver.Close()
+
+	if err := common.CleanStalePorts(ovsDriver, netconf.Name, validAttachments, netconf.PortSecurity); err != nil {
+		return err
+	}
+
+	if err := cleanStaleCache(netconf.Name, validAttachments); err != nil {
+		return err
+	}
+
+	if netconf.IPAM.Type != "" {
+		if err := invoke.DelegateGC(ctx, netconf.IPAM.Type, args.StdinData, nil); err != nil {
+			return fmt.Errorf("failed to run GC with IPAM plugin type %q: %v", netconf.IPAM.Type, err)
+		}
+	}
+
+	return nil
+}
+
+// cleanStaleCache removes cached NetConfs and device information files of the
+// given network whose attachment is not in the set of valid attachments.
+func cleanStaleCache(netName string, validAttachments map[string]bool) error {
+	cRefs, err := utils.ListCache()
+	if err != nil {
+		return err
+	}
+	for _, cRef := range cRefs {
+		if validAttachments[cRef] {
+			continue
+		}
+		cache, err := config.LoadConfFromCache(cRef)
+		if err != nil {
+			// not a CachedNetConf (e.g. mirror plugins share the cache dir)
+			continue
+		}
+		if cache.Netconf == nil || cache.Netconf.Name != netName {
+			continue
+		}
+		log.Printf("Info: cached NetConf %s is stale: removing it", cRef)
+		if err := utils.CleanCache(cRef); err != nil {
+			log.Printf("Failed cleaning up cache: %v", err)
+		}
+		if err := deviceinfo.CleanDeviceInfo(deviceinfo.DeviceInfoPath(netName, cRef)); err != nil {
+			log.Printf("Failed cleaning up device info: %v", err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,236 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package portsecurity manages OpenFlow rules which only allow traffic with
+// the MAC and IP addresses assigned to a port to enter the bridge from it.
+package portsecurity
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// priority of the rules dropping all other traffic from the port
+	dropPriority = 100
+	// priority of the rules allowing traffic with the port addresses
+	allowPriority = 110
+	// priority of the rule dropping neighbor advertisements not covered
+	// by the allowed targets
+	ndDropPriority = 120
+	// priority of the rules allowing neighbor advertisements for the port
+	// addresses
+	ndAllowPriority = 130
+
+	icmp6NeighborSolicitation  = 135
+	icmp6NeighborAdvertisement = 136
+	icmp6MLDv2Report           = 143
+)
+
+const unixSocketPrefix = "unix:"
+
+// OfctlPath path of the ovs-ofctl binary used to manage the flows
+var OfctlPath = "ovs-ofctl"
+
+// Cookie returns the cookie identifying the flows of a port
+func Cookie(portName string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(portName))
+	return h.Sum64()
+}
+
+// Flows returns the flows allowing only traffic with the given MAC and IP
+// addresses to enter the bridge from the given ofport. When no IP addresses
+// are given, only the source MAC address is enforced.
+func Flows(cookie uint64, ofport int, mac string, ips []net.IP) []string {
+	prefix := func(priority int) string {
+		return fmt.Sprintf("cookie=%#x,priority=%d,in_port=%d", cookie, priority, ofport)
+	}
+
+	flows := []string{fmt.Sprintf("%s,actions=drop", prefix(dropPriority))}
+
+	if len(ips) == 0 {
+		return append(flows, fmt.Sprintf("%s,dl_src=%s,actions=NORMAL", prefix(allowPriority), mac))
+	}
+
+	hasIPv6 := false
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			flows = append(flows,
+				fmt.Sprintf("%s,dl_src=%s,arp,arp_sha=%s,arp_spa=%s,actions=NORMAL", prefix(allowPriority), mac, mac, ip),
+				fmt.Sprintf("%s,dl_src=%s,ip,nw_src=%s,actions=NORMAL", prefix(allowPriority), mac, ip),
+			)
+			continue
+		}
+		hasIPv6 = true
+		flows = append(flows,
+			fmt.Sprintf("%s,dl_src=%s,ipv6,ipv6_src=%s,actions=NORMAL", prefix(allowPriority), mac, ip),
+			fmt.Sprintf("%s,dl_src=%s,icmp6,icmp_type=%d,nd_target=%s,actions=NORMAL",
+				prefix(ndAllowPriority), mac, icmp6NeighborAdvertisement, ip),
+		)
+	}
+
+	if hasIPv6 {
+		flows = append(flows,
+			// link-local addresses are generated by the container kernel
+			fmt.Sprintf("%s,dl_src=%s,ipv6,ipv6_src=fe80::/10,actions=NORMAL", prefix(allowPriority), mac),
+			fmt.Sprintf("%s,dl_src=%s,icmp6,icmp_type=%d,nd_target=fe80::/10,actions=NORMAL",
+				prefix(ndAllowPriority), mac, icmp6NeighborAdvertisement),
+			// duplicate address detection is sent from the unspecified address
+			fmt.Sprintf("%s,dl_src=%s,icmp6,ipv6_src=::,icmp_type=%d,actions=NORMAL",
+				prefix(allowPriority), mac, icmp6NeighborSolicitation),
+			fmt.Sprintf("%s,dl_src=%s,icmp6,ipv6_src=::,icmp_type=%d,actions=NORMAL",
+				prefix(allowPriority), mac, icmp6MLDv2Report),
+			// neighbor advertisements must only announce the port addresses
+			fmt.Sprintf("%s,icmp6,icmp_type=%d,actions=drop", prefix(ndDropPriority), icmp6NeighborAdvertisement),
+		)
+	}
+
+	return flows
+}
+
+// Target returns the OpenFlow target of ovs-ofctl for the bridge, the
+// management socket of the bridge in the run directory of the given ovsdb
+// unix socket. ovs-ofctl looks the socket up in its default run directory
+// when socketFile is empty. Other ovsdb endpoints are rejected since the
+// flows can only be managed locally.
+func Target(socketFile, bridgeName string) (string, error) {
+	if socketFile == "" {
+		return bridgeName, nil
+	}
+	if !strings.HasPrefix(socketFile, unixSocketPrefix) {
+		return "", fmt.Errorf("port security requires a unix ovsdb socket, got %s", socketFile)
+	}
+	runDir := filepath.Dir(strings.TrimPrefix(socketFile, unixSocketPrefix))
+	return unixSocketPrefix + filepath.Join(runDir, bridgeName+".mgmt"), nil
+}
+
+// AddFlows installs the port security flows of a port on the bridge of the
+// ovs-ofctl target
+func AddFlows(target, portName string, ofport int, mac string, ips []net.IP) error {
+	flows := Flows(Cookie(portName), ofport, mac, ips)
+	stdin := strings.NewReader(strings.Join(flows, "\n") + "\n")
+	if _, err := ofctl(stdin, "add-flows", target, "-"); err != nil {
+		return fmt.Errorf("failed to add port security flows of port %s: %v", portName, err)
+	}
+	return nil
+}
+
+// DelFlows removes the port security flows of a port from the bridge of the
+// ovs-ofctl target
+func DelFlows(target, portName string) error {
+	if _, err := ofctl(nil, "del-flows", target, cookieMatch(portName)); err != nil {
+		return fmt.Errorf("failed to remove port security flows of port %s: %v", portName, err)
+	}
+	return nil
+}
+
+// CheckFlows verifies that the installed port security flows of a port on
+// the bridge of the ovs-ofctl target are exactly the expected ones
+func CheckFlows(target, portName string, ofport int, mac string, ips []net.IP) error {
+	output, err := ofctl(nil, "--no-names", "dump-flows", target, cookieMatch(portName))
+	if err != nil {
+		return fmt.Errorf("failed to dump port security flows of port %s: %v", portName, err)
+	}
+
+	installed := map[string]int{}
+	for _, line := range strings.Split(output, "\n") {
+		if flow, ok := normalizeFlow(line); ok {
+			installed[flow]++
+		}
+	}
+
+	var missing []string
+	for _, expected := range Flows(Cookie(portName), ofport, mac, ips) {
+		flow, _ := normalizeFlow(expected)
+		if installed[flow] == 0 {
+			missing = append(missing, flow)
+			continue
+		}
+		installed[flow]--
+	}
+	var unexpected []string
+	for flow, count := range installed {
+		for ; count > 0; count-- {
+			unexpected = append(unexpected, flow)
+		}
+	}
+	if len(missing) > 0 || len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		return fmt.Errorf("port security flows mismatch for port %s. missing=%q,unexpected=%q", portName, missing, unexpected)
+	}
+	return nil
+}
+
+// flowStatsFields are the fields ovs-ofctl dump-flows prints along with a
+// flow which are not part of its priority, match or actions
+var flowStatsFields = map[string]bool{
+	"cookie":    true,
+	"duration":  true,
+	"table":     true,
+	"n_packets": true,
+	"n_bytes":   true,
+	"idle_age":  true,
+	"hard_age":  true,
+}
+
+// normalizeFlow returns a flow, either as given to add-flows or as printed by
+// dump-flows, in a canonical form of its priority, match and actions. The
+// match fields are sorted since ovs-ofctl prints them in its own order. It
+// returns false when the line isn't a flow.
+func normalizeFlow(line string) (string, bool) {
+	index := strings.Index(line, "actions=")
+	if index < 0 {
+		return "", false
+	}
+	actions := strings.ToLower(strings.TrimSpace(line[index+len("actions="):]))
+
+	priority := ""
+	var match []string
+	for _, field := range strings.Split(line[:index], ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		key := strings.SplitN(field, "=", 2)[0]
+		switch {
+		case field == "" || flowStatsFields[key]:
+		case key == "priority":
+			priority = field
+		default:
+			match = append(match, field)
+		}
+	}
+	sort.Strings(match)
+	return fmt.Sprintf("%s,%s,actions=%s", priority, strings.Join(match, ","), actions), true
+}
+
+func cookieMatch(portName string) string {
+	return fmt.Sprintf("cookie=%#x/-1", Cookie(portName))
+}
+
+func ofctl(stdin *strings.Reader, args ...string) (string, error) {
+	cmd := exec.Command(OfctlPath, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %v: %s", OfctlPath, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
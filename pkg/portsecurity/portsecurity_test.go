@@ -0,0 +1,128 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package portsecurity
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const mac = "0a:58:0a:f4:00:07"
+
+var _ = Describe("Cookie", func() {
+	It("should be stable for the same port", func() {
+		Expect(Cookie("veth1234")).To(Equal(Cookie("veth1234")))
+	})
+
+	It("should differ between ports", func() {
+		Expect(Cookie("veth1234")).NotTo(Equal(Cookie("veth5678")))
+	})
+})
+
+var _ = Describe("Flows", func() {
+	It("should only enforce the MAC address when there are no IPs", func() {
+		flows := Flows(0x1, 5, mac, nil)
+		Expect(flows).To(Equal([]string{
+			"cookie=0x1,priority=100,in_port=5,actions=drop",
+			"cookie=0x1,priority=110,in_port=5,dl_src=" + mac + ",actions=NORMAL",
+		}))
+	})
+
+	It("should allow ARP and IP traffic for IPv4 addresses", func() {
+		flows := Flows(0x1, 5, mac, []net.IP{net.ParseIP("10.244.0.7")})
+		Expect(flows).To(ConsistOf(
+			"cookie=0x1,priority=100,in_port=5,actions=drop",
+			"cookie=0x1,priority=110,in_port=5,dl_src="+mac+",arp,arp_sha="+mac+",arp_spa=10.244.0.7,actions=NORMAL",
+			"cookie=0x1,priority=110,in_port=5,dl_src="+mac+",ip,nw_src=10.244.0.7,actions=NORMAL",
+		))
+	})
+
+	It("should restrict neighbor advertisements for IPv6 addresses", func() {
+		flows := Flows(0x1, 5, mac, []net.IP{net.ParseIP("fd00::7")})
+		Expect(flows).To(ContainElements(
+			"cookie=0x1,priority=110,in_port=5,dl_src="+mac+",ipv6,ipv6_src=fd00::7,actions=NORMAL",
+			"cookie=0x1,priority=130,in_port=5,dl_src="+mac+",icmp6,icmp_type=136,nd_target=fd00::7,actions=NORMAL",
+			"cookie=0x1,priority=110,in_port=5,dl_src="+mac+",ipv6,ipv6_src=fe80::/10,actions=NORMAL",
+			"cookie=0x1,priority=120,in_port=5,icmp6,icmp_type=136,actions=drop",
+		))
+		Expect(flows).To(HaveLen(8))
+	})
+})
+
+var _ = Describe("CheckFlows", func() {
+	var origOfctlPath string
+
+	BeforeEach(func() {
+		origOfctlPath = OfctlPath
+	})
+
+	AfterEach(func() {
+		OfctlPath = origOfctlPath
+	})
+
+	fakeOfctl := func(flows ...string) {
+		dump := "NXST_FLOW reply (xid=0x4):\n"
+		for _, flow := range flows {
+			dump += " cookie=0x2f9c1a4d1d6b4c3e, duration=1.042s, table=0, n_packets=0, n_bytes=0, idle_age=1, " + flow + "\n"
+		}
+		script := filepath.Join(GinkgoT().TempDir(), "ovs-ofctl")
+		Expect(os.WriteFile(script, []byte("#!/bin/sh\ncat <<EOF\n"+dump+"EOF\n"), 0700)).To(Succeed())
+		OfctlPath = script
+	}
+
+	// the flows of the IPv4 address as printed by ovs-ofctl dump-flows
+	dropFlow := "priority=100,in_port=5 actions=drop"
+	arpFlow := "priority=110,arp,in_port=5,dl_src=" + mac + ",arp_spa=10.244.0.7,arp_sha=" + mac + " actions=NORMAL"
+	ipFlow := "priority=110,ip,in_port=5,dl_src=" + mac + ",nw_src=10.244.0.7 actions=NORMAL"
+	ips := []net.IP{net.ParseIP("10.244.0.7")}
+
+	It("should succeed when all the flows are installed", func() {
+		fakeOfctl(dropFlow, arpFlow, ipFlow)
+		Expect(CheckFlows("br1", "veth1234", 5, mac, ips)).To(Succeed())
+	})
+
+	It("should fail when flows are missing", func() {
+		fakeOfctl(dropFlow)
+		Expect(CheckFlows("br1", "veth1234", 5, mac, ips)).To(HaveOccurred())
+	})
+
+	It("should fail when the flows differ from the expected ones", func() {
+		fakeOfctl(dropFlow, arpFlow, strings.Replace(ipFlow, "nw_src=10.244.0.7", "nw_src=10.244.0.8", 1))
+		err := CheckFlows("br1", "veth1234", 5, mac, ips)
+		Expect(err).To(MatchError(ContainSubstring("nw_src=10.244.0.8")))
+	})
+})
+
+var _ = Describe("Target", func() {
+	It("should use the management socket next to the ovsdb socket", func() {
+		target, err := Target("unix:/run/ovs/db.sock", "br1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal("unix:/run/ovs/br1.mgmt"))
+	})
+
+	It("should let ovs-ofctl find the bridge without an ovsdb socket", func() {
+		Expect(Target("", "br1")).To(Equal("br1"))
+	})
+
+	It("should reject remote ovsdb endpoints", func() {
+		_, err := Target("tcp:10.0.0.1:6640", "br1")
+		Expect(err).To(HaveOccurred())
+		_, err = Target("ssl:10.0.0.1:6640", "br1")
+		Expect(err).To(HaveOccurred())
+	})
+})
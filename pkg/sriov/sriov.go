@@ -14,6 +14,7 @@ package sriov
 
 import (
 	"fmt"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
@@ -22,6 +23,7 @@ import (
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
+	netv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	"github.com/k8snetworkplumbingwg/sriovnet"
 	"github.com/vishvananda/netlink"
 
@@ -190,7 +192,7 @@ func GetNetVF(deviceID string) (string, error) {
 
 // setupKernelSriovContIface moves smartVF into container namespace,
 // configures the smartVF and also fills in the contIface fields
-func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface, deviceID string, pfLink netlink.Link, vfIdx int, ifName string, hwaddr net.HardwareAddr, mtu int) error {
+func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface, deviceID string, pfLink netlink.Link, vfIdx int, ifName string, hwaddr net.HardwareAddr, mtu int, rollback *common.Rollback) error {
 	vfNetdevice, err := GetNetVF(deviceID)
 	if err != nil {
 		return err
@@ -199,7 +201,7 @@ func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface,
 	// if MAC address is provided, set it to the VF by using PF netlink
 	// which is accessible in the host namespace, not in the container namespace
 	if hwaddr != nil {
-		if err := netlink.LinkSetVfHardwareAddr(pfLink, vfIdx, hwaddr); err != nil {
+		if err := setVfHardwareAddr(pfLink, vfIdx, hwaddr, rollback); err != nil {
 			return err
 		}
 	}
@@ -209,6 +211,11 @@ func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface,
 	if err != nil {
 		return err
 	}
+	rollback.Push("move of VF "+vfNetdevice, func() error {
+		return contNetns.Do(func(hostNS ns.NetNS) error {
+			return moveIfToNetns(vfNetdevice, hostNS)
+		})
+	})
 
 	err = contNetns.Do(func(hostNS ns.NetNS) error {
 		contIface.Name = ifName
@@ -216,6 +223,12 @@ func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface,
 		if err != nil {
 			return err
 		}
+		rollback.Push("rename of VF "+vfNetdevice, func() error {
+			return contNetns.Do(func(ns.NetNS) error {
+				_, err := renameLink(ifName, vfNetdevice)
+				return err
+			})
+		})
 		link, err := netlink.LinkByName(contIface.Name)
 		if err != nil {
 			return err
@@ -223,17 +236,29 @@ func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface,
 		// if MAC address is provided, set it to the kernel VF netdevice
 		// otherwise, read the MAC address from the kernel VF netdevice
 		if hwaddr != nil {
+			origMac := link.Attrs().HardwareAddr
 			if err = netlink.LinkSetHardwareAddr(link, hwaddr); err != nil {
 				return err
 			}
+			rollback.Push("MAC of VF "+vfNetdevice, func() error {
+				return contNetns.Do(func(ns.NetNS) error {
+					return setLinkHardwareAddr(ifName, origMac)
+				})
+			})
 			contIface.Mac = hwaddr.String()
 		} else {
 			contIface.Mac = link.Attrs().HardwareAddr.String()
 		}
 		if mtu != 0 {
+			origMTU := link.Attrs().MTU
 			if err = netlink.LinkSetMTU(link, mtu); err != nil {
 				return err
 			}
+			rollback.Push("MTU of VF "+vfNetdevice, func() error {
+				return contNetns.Do(func(ns.NetNS) error {
+					return setLinkMTU(ifName, origMTU)
+				})
+			})
 		}
 		err = netlink.LinkSetUp(link)
 		if err != nil {
@@ -251,13 +276,13 @@ func setupKernelSriovContIface(contNetns ns.NetNS, contIface *current.Interface,
 }
 
 // setupUserspaceSriovContIface configures smartVF via PF netlink and fills in the contIface fields
-func setupUserspaceSriovContIface(contNetns ns.NetNS, contIface *current.Interface, pfLink netlink.Link, vfIdx int, ifName string, hwaddr net.HardwareAddr) error {
+func setupUserspaceSriovContIface(contNetns ns.NetNS, contIface *current.Interface, pfLink netlink.Link, vfIdx int, ifName string, hwaddr net.HardwareAddr, rollback *common.Rollback) error {
 	contIface.Name = ifName
 	contIface.Sandbox = contNetns.Path()
 
 	// if MAC address is provided, set it to the VF by using PF netlink
 	if hwaddr != nil {
-		if err := netlink.LinkSetVfHardwareAddr(pfLink, vfIdx, hwaddr); err != nil {
+		if err := setVfHardwareAddr(pfLink, vfIdx, hwaddr, rollback); err != nil {
 			return err
 		}
 		contIface.Mac = hwaddr.String()
@@ -269,8 +294,38 @@ func setupUserspaceSriovContIface(contNetns ns.NetNS, contIface *current.Interfa
 	return nil
 }
 
-// SetupSriovInterface configures smartVF and returns VF's representor device as host interface and VF's netdevice as container interface
-func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mtu int, deviceID string, userspaceMode bool) (*current.Interface, *current.Interface, error) {
+// setVfHardwareAddr sets the administrative MAC address of the VF through
+// the PF, the previous one is restored on rollback
+func setVfHardwareAddr(pfLink netlink.Link, vfIdx int, hwaddr net.HardwareAddr, rollback *common.Rollback) error {
+	origMac := pfLink.Attrs().Vfs[vfIdx].Mac
+	if err := netlink.LinkSetVfHardwareAddr(pfLink, vfIdx, hwaddr); err != nil {
+		return err
+	}
+	rollback.Push(fmt.Sprintf("MAC of VF %d of %s", vfIdx, pfLink.Attrs().Name), func() error {
+		return netlink.LinkSetVfHardwareAddr(pfLink, vfIdx, origMac)
+	})
+	return nil
+}
+
+func setLinkHardwareAddr(name string, hwaddr net.HardwareAddr) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetHardwareAddr(link, hwaddr)
+}
+
+func setLinkMTU(name string, mtu int) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetMTU(link, mtu)
+}
+
+// SetupSriovInterface configures smartVF and returns VF's representor device as host interface and VF's netdevice as container interface,
+// the changes made to the VF and its representor are recorded in rollback
+func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mtu int, deviceID string, userspaceMode bool, rollback *common.Rollback) (*current.Interface, *current.Interface, error) {
 	hostIface := &current.Interface{}
 	contIface := &current.Interface{}
 
@@ -319,19 +374,23 @@ func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mt
 
 	// set MTU on smart VF representor
 	if mtu != 0 {
+		origMTU := link.Attrs().MTU
 		if err = netlink.LinkSetMTU(link, mtu); err != nil {
 			return nil, nil, fmt.Errorf("failed to set MTU on %s: %v", hostIface.Name, err)
 		}
+		rollback.Push("MTU of "+hostIface.Name, func() error {
+			return netlink.LinkSetMTU(link, origMTU)
+		})
 	}
 
 	if !userspaceMode {
 		// configure the smart VF netdevice directly in the container namespace
-		if err = setupKernelSriovContIface(contNetns, contIface, deviceID, pfLink, vfIdx, ifName, hwaddr, mtu); err != nil {
+		if err = setupKernelSriovContIface(contNetns, contIface, deviceID, pfLink, vfIdx, ifName, hwaddr, mtu, rollback); err != nil {
 			return nil, nil, err
 		}
 	} else {
 		// configure the smart VF netdevice via PF netlink
-		if err = setupUserspaceSriovContIface(contNetns, contIface, pfLink, vfIdx, ifName, hwaddr); err != nil {
+		if err = setupUserspaceSriovContIface(contNetns, contIface, pfLink, vfIdx, ifName, hwaddr, rollback); err != nil {
 			return nil, nil, err
 		}
 	}
@@ -339,6 +398,25 @@ func SetupSriovInterface(contNetns ns.NetNS, containerID, ifName, mac string, mt
 	return hostIface, contIface, nil
 }
 
+// deviceInfo returns the device information of a VF attached through its
+// representor
+func deviceInfo(deviceID, rep string) *netv1.DeviceInfo {
+	pfPciAddress, err := sriovnet.GetPfPciFromVfPci(deviceID)
+	if err != nil {
+		// the device information is still useful without it
+		log.Printf("Failed to get PF of VF %s: %v", deviceID, err)
+	}
+	return &netv1.DeviceInfo{
+		Type:    netv1.DeviceInfoTypePCI,
+		Version: netv1.DeviceInfoVersion,
+		Pci: &netv1.PciDevice{
+			PciAddress:        deviceID,
+			PfPciAddress:      pfPciAddress,
+			RepresentorDevice: rep,
+		},
+	}
+}
+
 func moveIfToNetns(ifname string, netns ns.NetNS) error {
 	vfDev, err := netlink.LinkByName(ifname)
 	if err != nil {
@@ -18,6 +18,7 @@ package types
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
@@ -30,24 +31,75 @@ type NetConfs interface {
 
 type RuntimeConfig struct {
 	types.CommonArgs
-	CNIDeviceInfoFile string `json:"CNIDeviceInfoFile,omitempty"`
+	CNIDeviceInfoFile string          `json:"CNIDeviceInfoFile,omitempty"`
+	Bandwidth         *BandwidthEntry `json:"bandwidth,omitempty"`
+	Mac               string          `json:"mac,omitempty"`
+	IPs               []string        `json:"ips,omitempty"`
+}
+
+// SSLConfig holds the paths of the CA certificate, client certificate and
+// private key used to connect to an ssl: socket_file
+type SSLConfig struct {
+	CACert string `json:"ssl_ca_cert,omitempty"`
+	Cert   string `json:"ssl_cert,omitempty"`
+	Key    string `json:"ssl_key,omitempty"`
+}
+
+// OvsdbConfig holds the settings of the connection to ovsdb, the timeouts
+// default to the ones of the ovsdb package when zero
+type OvsdbConfig struct {
+	SocketFile string `json:"socket_file"`
+	SSLConfig
+	ConnectTimeout  int `json:"ovsdb_connect_timeout,omitempty"`  // in milliseconds, retries included
+	TransactTimeout int `json:"ovsdb_transact_timeout,omitempty"` // in milliseconds, per transaction
+}
+
+// BandwidthEntry is the standard "bandwidth" runtime capability, rates are
+// in bits per second and bursts in bits, seen from the container.
+type BandwidthEntry struct {
+	IngressRate  uint64 `json:"ingressRate"`
+	IngressBurst uint64 `json:"ingressBurst"`
+	EgressRate   uint64 `json:"egressRate"`
+	EgressBurst  uint64 `json:"egressBurst"`
 }
 
 // NetConf extends types.NetConf for ovs-cni
 type NetConf struct {
 	types.NetConf
+	OvsdbConfig
 	BrName                 string         `json:"bridge,omitempty"`
 	VlanTag                *uint          `json:"vlan"`
-	MTU                    int            `json:"mtu"`
+	MTU                    MTU            `json:"mtu"`
+	MTUOverhead            int            `json:"mtuOverhead,omitempty"` // subtracted from the uplink MTU with mtu auto
 	Trunk                  []*Trunk       `json:"trunk,omitempty"`
-	DeviceID               string         `json:"deviceID"`       // PCI address of a VF in valid sysfs format
-	OfportRequest          uint           `json:"ofport_request"` // OpenFlow port number in range 1 to 65,279
-	InterfaceType          string         `json:"interface_type"` // The type of interface on ovs.
+	AllowedVlans           []*Trunk       `json:"allowedVlans,omitempty"`
+	VlanMode               string         `json:"vlanMode,omitempty"`    // native-tagged, native-untagged or dot1q-tunnel
+	Cvlans                 []*Trunk       `json:"cvlans,omitempty"`      // customer VLANs of a dot1q-tunnel port
+	QinqEthtype            string         `json:"qinqEthtype,omitempty"` // 802.1ad or 802.1q, ethertype of a dot1q-tunnel port
+	DeviceID               string         `json:"deviceID"`              // PCI address of a VF in valid sysfs format
+	OfportRequest          uint           `json:"ofport_request"`        // OpenFlow port number in range 1 to 65,279
+	InterfaceType          string         `json:"interface_type"`        // The type of interface on ovs.
+	PortMode               string         `json:"portMode,omitempty"`    // How the container is attached, "veth" (default), "internal" or "vhost-user".
+	VhostUserSocketDir     string         `json:"vhostUserSocketDir,omitempty"`
 	ConfigurationPath      string         `json:"configuration_path"`
-	SocketFile             string         `json:"socket_file"`
 	LinkStateCheckRetries  int            `json:"link_state_check_retries"`
 	LinkStateCheckInterval int            `json:"link_state_check_interval"`
+	IngressPolicingRate    uint           `json:"ingress_policing_rate"`  // in kbps, traffic received from the container
+	IngressPolicingBurst   uint           `json:"ingress_policing_burst"` // in kb
+	EgressShapingRate      uint64         `json:"egress_shaping_rate"`    // in bps, traffic sent to the container
+	EgressShapingBurst     uint64         `json:"egress_shaping_burst"`   // in bits
+	PortSecurity           bool           `json:"portSecurity"`           // allow only the port MAC and IPs
+	CreateBridge           bool           `json:"createBridge"`           // create the bridge on ADD when missing
+	Uplink                 string         `json:"uplink,omitempty"`       // host interface attached to a created bridge
+	DatapathType           string         `json:"datapathType,omitempty"` // datapath type of a created bridge
+	FailMode               string         `json:"failMode,omitempty"`     // fail mode of a created bridge
 	RuntimeConfig          *RuntimeConfig `json:"runtimeConfig,omitempty"`
+	WaitForOvnInstalled    *bool          `json:"waitForOvnInstalled,omitempty"` // wait for ovn-controller to install the ovnPort
+	OvnInstalledTimeout    int            `json:"ovnInstalledTimeout,omitempty"` // in seconds
+
+	// HostIfaceNaming names the host interfaces after the attachments,
+	// rather than randomly, e.g. to write flow rules ahead of time
+	HostIfaceNaming *HostIfaceNaming `json:"hostIfaceNaming,omitempty"`
 
 	// Args carries CNI 0.4.0+ "args" passthrough. Meta-plugins such as
 	// multus-cni inject per-invocation parameters (e.g. ovnPort) here from a
@@ -81,6 +133,7 @@ func (n NetConf) MarshalJSON() ([]byte, error) {
 // MirrorNetConf extends types.NetConf for ovs-mirrors
 type MirrorNetConf struct {
 	types.NetConf
+	OvsdbConfig
 
 	// support chaining for master interface and IP decisions
 	// occurring prior to running mirror plugin
@@ -89,7 +142,6 @@ type MirrorNetConf struct {
 
 	BrName            string    `json:"bridge,omitempty"`
 	ConfigurationPath string    `json:"configuration_path"`
-	SocketFile        string    `json:"socket_file"`
 	Mirrors           []*Mirror `json:"mirrors"`
 }
 
@@ -111,6 +163,46 @@ type Mirror struct {
 	Egress  bool   `json:"egress,omitempty"`
 }
 
+// MTU of the container interface, given either as a number or as "auto"
+type MTU int
+
+// MTUAuto derives the MTU of the container interface from the bridge uplinks
+const MTUAuto MTU = -1
+
+const mtuAutoValue = "auto"
+
+// UnmarshalJSON implements custom JSON unmarshaling for MTU, accepting "auto"
+// along with numbers
+func (m *MTU) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		if value != mtuAutoValue {
+			return fmt.Errorf("invalid mtu %q, expected a number or %q", value, mtuAutoValue)
+		}
+		*m = MTUAuto
+		return nil
+	}
+
+	var mtu int
+	if err := json.Unmarshal(data, &mtu); err != nil {
+		return fmt.Errorf("invalid mtu %s, expected a number or %q", data, mtuAutoValue)
+	}
+	*m = MTU(mtu)
+	return nil
+}
+
+// MarshalJSON implements custom JSON marshaling for MTU
+func (m MTU) MarshalJSON() ([]byte, error) {
+	if m == MTUAuto {
+		return json.Marshal(mtuAutoValue)
+	}
+	return json.Marshal(int(m))
+}
+
 // Trunk containing selective vlan IDs
 type Trunk struct {
 	MinID *uint `json:"minID,omitempty"`
@@ -127,6 +219,28 @@ const (
 	VdpaDeviceTypeKernelVhost = "VdpaKernelVhost"
 )
 
+// Port modes selecting how a container interface is attached to the bridge
+const (
+	PortModeVeth      = "veth"
+	PortModeInternal  = "internal"
+	PortModeVhostUser = "vhost-user"
+)
+
+// HostIfaceNaming selects how the host interfaces of the attachments, and
+// hence their OVS ports, are named
+type HostIfaceNaming struct {
+	Mode     string `json:"mode,omitempty"`     // "random" (default), "hash" or "template"
+	Prefix   string `json:"prefix,omitempty"`   // defaults to the one of the random names
+	Template string `json:"template,omitempty"` // e.g. "{prefix}{podName}-{ifName}"
+}
+
+// Host interface naming modes
+const (
+	HostIfaceNamingRandom   = "random"
+	HostIfaceNamingHash     = "hash"
+	HostIfaceNamingTemplate = "template"
+)
+
 // CachedNetConf containing NetConfig, original smartnic vf interface name
 // kernel/userspace device driver mode of the smartnic vf interface and
 // the vdpa device type (the last three are set only in case of ovs
@@ -138,6 +252,7 @@ type CachedNetConf struct {
 	OrigIfName    string
 	UserspaceMode bool
 	VdpaType      VdpaDeviceType
+	Result        *current.Result `json:",omitempty"` // result of a completed ADD
 }
 
 // CachedPrevResultNetConf containing PrevResult.
@@ -154,5 +269,11 @@ type EnvArgs struct {
 	types.CommonArgs
 	MAC         types.UnmarshallableString `json:"mac,omitempty"`
 	OvnPort     types.UnmarshallableString `json:"ovnPort,omitempty"`
+	Vlan        types.UnmarshallableString `json:"vlan,omitempty"`
+	Trunk       types.UnmarshallableString `json:"trunk,omitempty"` // e.g. 10,20-30
+	VlanMode    types.UnmarshallableString `json:"vlanMode,omitempty"`
 	K8S_POD_UID types.UnmarshallableString
+
+	K8S_POD_NAMESPACE types.UnmarshallableString
+	K8S_POD_NAME      types.UnmarshallableString
 }
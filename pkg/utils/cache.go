@@ -19,8 +19,10 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -32,26 +34,22 @@ var (
 	rootDir = ""
 )
 
-// SaveCache takes in key as string and a json encoded struct Conf and save this Conf in cache dir
+// prefix of the temporary files a conf is written to before being renamed
+const tmpFilePrefix = "."
+
+// SaveCache takes in key as string and a json encoded struct Conf and save this Conf in cache dir.
+// The conf is replaced atomically, a crash while saving it leaves the previous one in place.
 func SaveCache(key string, conf interface{}) error {
 	confBytes, err := json.Marshal(conf)
 	if err != nil {
 		return fmt.Errorf("error serializing delegate conf: %v", err)
 	}
-	path := getKeyPath(key)
-	cacheDir := filepath.Dir(path)
 	// save the rendered conf for cmdDel
-	if err = os.MkdirAll(cacheDir, 0700); err != nil {
-		return fmt.Errorf("failed to create the sriov data directory(%q): %v", cacheDir, err)
-	}
-	err = os.WriteFile(path, confBytes, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write container data in the path(%q): %v", path, err)
-	}
-	return nil
+	return writeCacheFile(getKeyPath(key), confBytes)
 }
 
-// ReadCache read cached conf from disk for the given key and returns data in byte array
+// ReadCache read cached conf from disk for the given key and returns data in byte array.
+// A conf found in the old cache dir is moved to the current one.
 func ReadCache(key string) ([]byte, error) {
 	path := getKeyPath(key)
 	oldPath := getOldKeyPath(key)
@@ -64,6 +62,9 @@ func ReadCache(key string) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		if data != nil {
+			migrateCacheFile(oldPath, path, data)
+		}
 	}
 	if data == nil {
 		return nil, fmt.Errorf("failed to read container data from old(%q) and current(%q) path: not found", oldPath, path)
@@ -79,6 +80,30 @@ func CleanCache(key string) error {
 	return removeCacheFile(getOldKeyPath(key))
 }
 
+// ListCache returns the keys of all cached confs found in the current and the
+// old cache dir
+func ListCache() ([]string, error) {
+	seen := make(map[string]bool)
+	keys := []string{}
+	for _, dir := range []string{getKeyPath(""), getOldKeyPath("")} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to list container data in the path(%q): %v", dir, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), tmpFilePrefix) || seen[entry.Name()] {
+				continue
+			}
+			seen[entry.Name()] = true
+			keys = append(keys, entry.Name())
+		}
+	}
+	return keys, nil
+}
+
 // read content from the file in the provided path, returns nil, nil
 // if file not found
 func readCacheFile(path string) ([]byte, error) {
@@ -92,6 +117,75 @@ func readCacheFile(path string) ([]byte, error) {
 	return data, nil
 }
 
+// write content to the file in the provided path through a temporary file
+// renamed over it
+func writeCacheFile(path string, data []byte) error {
+	cacheDir := filepath.Dir(path)
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create the cache directory(%q): %v", cacheDir, err)
+	}
+	if err := WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write container data in the path(%q): %v", path, err)
+	}
+	return nil
+}
+
+// WriteFileAtomic replaces the file in the provided path with the given
+// content through a temporary file renamed over it, both the file and the
+// rename are synced to disk. A crash while writing leaves the previous file
+// in place, and a read-only file can be replaced.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, tmpFilePrefix+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Chmod(perm)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// sync the entries of the directory to disk, e.g. after a rename
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to sync the directory(%q): %v", path, err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync the directory(%q): %v", path, err)
+	}
+	return nil
+}
+
+// move the content of a file in the old cache dir to the provided path,
+// best-effort since the content was read already
+func migrateCacheFile(oldPath, path string, data []byte) {
+	if err := writeCacheFile(path, data); err != nil {
+		log.Printf("Failed to migrate container data from the path(%q): %v", oldPath, err)
+		return
+	}
+	if err := removeCacheFile(oldPath); err != nil {
+		log.Printf("Failed to migrate container data from the path(%q): %v", oldPath, err)
+	}
+}
+
 // remove file in the provided path, returns nil if file not found
 func removeCacheFile(path string) error {
 	if err := os.RemoveAll(path); err != nil {
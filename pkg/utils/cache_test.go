@@ -67,6 +67,34 @@ var _ = Describe("Utils", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(data).To(Equal([]byte(`{"data":"test"}`)))
 		})
+		It("should move data from the old cache dir to the new one", func() {
+			origData := []byte(`{"data":"test"}`)
+			writeToCacheDir(tmpDir, "/tmp/ovscache", "key1", origData)
+			_, err := ReadCache("key1")
+			Expect(err).NotTo(HaveOccurred())
+			data, err := os.ReadFile(filepath.Join(tmpDir, "/var/lib/cni/ovs-cni/cache/key1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal(origData))
+			_, err = os.Stat(filepath.Join(tmpDir, "/tmp/ovscache/key1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("should replace saved data without leaving temporary files", func() {
+			Expect(SaveCache("key1", testConf{Data: "test"})).To(Succeed())
+			Expect(SaveCache("key1", testConf{Data: "test2"})).To(Succeed())
+			data, err := ReadCache("key1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"data":"test2"}`))
+			entries, err := os.ReadDir(filepath.Join(tmpDir, "/var/lib/cni/ovs-cni/cache"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+		It("should not list temporary files", func() {
+			writeToCacheDir(tmpDir, "/var/lib/cni/ovs-cni/cache", "key1", []byte(`{"data":"test"}`))
+			writeToCacheDir(tmpDir, "/var/lib/cni/ovs-cni/cache", ".key2.tmp123", []byte(`{"da`))
+			keys, err := ListCache()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key1"))
+		})
 		It("should return error if can't read data from new and old path", func() {
 			data, err := ReadCache("key1")
 			Expect(err).To(MatchError(ContainSubstring("not found")))
@@ -83,5 +111,20 @@ var _ = Describe("Utils", func() {
 		It("should not return error when clean called for unknown key", func() {
 			Expect(CleanCache("key1")).NotTo(HaveOccurred())
 		})
+		It("should list keys from old and new path", func() {
+			origData := []byte(`{"data":"test"}`)
+			writeToCacheDir(tmpDir, "/var/lib/cni/ovs-cni/cache", "key1", origData)
+			writeToCacheDir(tmpDir, "/var/lib/cni/ovs-cni/cache", "key2", origData)
+			writeToCacheDir(tmpDir, "/tmp/ovscache", "key2", origData)
+			writeToCacheDir(tmpDir, "/tmp/ovscache", "key3", origData)
+			keys, err := ListCache()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key1", "key2", "key3"))
+		})
+		It("should return empty list when cache dirs do not exist", func() {
+			keys, err := ListCache()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(BeEmpty())
+		})
 	})
 })
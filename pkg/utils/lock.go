@@ -0,0 +1,86 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+var (
+	// DefaultLockDir holds the per-bridge lock files
+	DefaultLockDir = "/var/lib/cni/ovs-cni/lock"
+)
+
+// BridgeLock is an exclusive lock on a bridge, shared by all the ovs-cni
+// processes of the node. It is released by the kernel if the process
+// holding it dies.
+type BridgeLock struct {
+	file *os.File
+}
+
+// LockBridge waits for the lock of the bridge, for at most the given
+// timeout. The lock is polled with backoff since a blocking flock can't be
+// interrupted.
+func LockBridge(bridgeName string, timeout time.Duration) (*BridgeLock, error) {
+	path := getLockPath(bridgeName)
+	lockDir := filepath.Dir(path)
+	if err := os.MkdirAll(lockDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the lock directory(%q): %v", lockDir, err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the lock of bridge %s: %v", bridgeName, err)
+	}
+
+	tryLock := func() error {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil || err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+			return err
+		}
+		return backoff.Permanent(err)
+	}
+	if err = backoff.Retry(tryLock, newLockBackoff(timeout)); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+			return nil, fmt.Errorf("timed out after %v waiting for the lock of bridge %s", timeout, bridgeName)
+		}
+		return nil, fmt.Errorf("failed to lock bridge %s: %v", bridgeName, err)
+	}
+	return &BridgeLock{file: file}, nil
+}
+
+// newLockBackoff returns the backoff between the attempts to take a held
+// lock, which gives up once the timeout expires
+func newLockBackoff(timeout time.Duration) backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 10 * time.Millisecond
+	b.MaxInterval = 200 * time.Millisecond
+	b.MaxElapsedTime = timeout
+	return b
+}
+
+// Unlock releases the lock of the bridge
+func (l *BridgeLock) Unlock() error {
+	// closing the file releases the lock
+	return l.file.Close()
+}
+
+func getLockPath(bridgeName string) string {
+	return filepath.Join(rootDir, DefaultLockDir, bridgeName)
+}
@@ -0,0 +1,83 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Utils", func() {
+	Context("BridgeLock", func() {
+		const lockTimeout = 5 * time.Second
+		var (
+			tmpDir string
+			err    error
+		)
+		BeforeEach(func() {
+			tmpDir, err = os.MkdirTemp("", "ovs-cni-lock-test*")
+			rootDir = tmpDir
+			Expect(err).NotTo(HaveOccurred())
+		})
+		AfterEach(func() {
+			rootDir = ""
+			Expect(os.RemoveAll(tmpDir)).NotTo(HaveOccurred())
+		})
+		It("should create the lock file of the bridge", func() {
+			lock, err := LockBridge("br1", lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Unlock()
+			_, err = os.Stat(filepath.Join(tmpDir, "/var/lib/cni/ovs-cni/lock/br1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should block until the lock of the bridge is released", func() {
+			lock, err := LockBridge("br1", lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+
+			locked := make(chan *BridgeLock)
+			go func() {
+				defer GinkgoRecover()
+				lock, err := LockBridge("br1", lockTimeout)
+				Expect(err).NotTo(HaveOccurred())
+				locked <- lock
+			}()
+			Consistently(locked, 200*time.Millisecond).ShouldNot(Receive())
+
+			Expect(lock.Unlock()).To(Succeed())
+			var secondLock *BridgeLock
+			Eventually(locked, time.Second).Should(Receive(&secondLock))
+			Expect(secondLock.Unlock()).To(Succeed())
+		})
+		It("should fail when the lock of the bridge isn't released in time", func() {
+			lock, err := LockBridge("br1", lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Unlock()
+
+			_, err = LockBridge("br1", 100*time.Millisecond)
+			Expect(err).To(MatchError(ContainSubstring("timed out")))
+		})
+		It("should not block on the lock of another bridge", func() {
+			lock, err := LockBridge("br1", lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Unlock()
+
+			otherLock, err := LockBridge("br2", lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherLock.Unlock()).To(Succeed())
+		})
+	})
+})
@@ -13,6 +13,7 @@
 package vdpa
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -23,13 +24,14 @@ import (
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/common"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/deviceinfo"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 )
 
-func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
+func CmdAdd(ctx context.Context, args *skel.CmdArgs, netconf *types.NetConf) (err error) {
 	envArgs, err := common.GetEnvArgs(args.Args)
 	if err != nil {
 		return err
@@ -44,16 +46,28 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 		contPodUid = string(envArgs.K8S_POD_UID)
 	}
 	common.ApplyConfArgsFallback(netconf, &mac, &ovnPort)
+	common.ApplyRuntimeConfigMac(netconf, &mac)
+	if err := common.ApplyVlanArgs(netconf, envArgs); err != nil {
+		return err
+	}
 
 	portCfg, err := common.ParseOvsPortConfig(netconf)
 	if err != nil {
 		return err
 	}
 
-	ovsDriver, err := ovsdb.NewOvsDriver(netconf.SocketFile)
+	if err := common.CheckPortSecurityOvnPort(netconf, ovnPort); err != nil {
+		return err
+	}
+
+	common.ResolveWaitForOvnInstalled(netconf, ovnPort)
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
 	if err != nil {
 		return err
 	}
+	defer ovsDriver.Close()
+
 	bridgeName, err := sriov.GetBridgeName(ovsDriver, netconf.BrName, ovnPort, netconf.DeviceID)
 	if err != nil {
 		return err
@@ -64,13 +78,19 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 	// use the right bridge name in CmdDel
 	netconf.BrName = bridgeName
 
-	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridgeName, netconf.SocketFile)
+	if netconf.CreateBridge {
+		if err := common.EnsureBridge(ovsDriver, bridgeName, netconf); err != nil {
+			return err
+		}
+	}
+
+	ovsBridgeDriver, err := ovsDriver.BridgeDriver(bridgeName)
 	if err != nil {
 		return err
 	}
 
-	// removes all ports whose interfaces have an error
-	if err := common.CleanPorts(ovsBridgeDriver); err != nil {
+	mtu, err := common.ResolveMTU(ovsBridgeDriver, netconf)
+	if err != nil {
 		return err
 	}
 
@@ -89,17 +109,35 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 		return err
 	}
 
+	// undo what has been done so far when ADD fails
+	rollback := &common.Rollback{}
+	defer func() {
+		if err != nil {
+			rollback.Run()
+		}
+	}()
+
 	// Cache NetConf for CmdDel
-	if err = utils.SaveCache(config.GetCRef(args.ContainerID, args.IfName),
-		&types.CachedNetConf{Netconf: netconf, UserspaceMode: false, VdpaType: vdpaDevType}); err != nil {
+	cRef := config.GetCRef(args.ContainerID, args.IfName)
+	cachedNetConf := &types.CachedNetConf{Netconf: netconf, UserspaceMode: false, VdpaType: vdpaDevType}
+	if err = config.SaveConfToCache(cRef, cachedNetConf); err != nil {
 		return fmt.Errorf("error saving NetConf %q", err)
 	}
+	rollback.Push("cache "+cRef, func() error {
+		return utils.CleanCache(cRef)
+	})
 
-	hostIface, contIface, err := setupVdpaInterface(contNetns, args.IfName, netconf.DeviceID, mac, vdpaDev, netconf.MTU)
+	hostIface, contIface, err := setupVdpaInterface(contNetns, args.IfName, netconf.DeviceID, mac, vdpaDev, mtu, rollback)
 	if err != nil {
 		return err
 	}
 
+	// Unlike veth pair, OVS port will not be automatically removed
+	// when the netns gets removed.
+	rollback.Push("port of "+hostIface.Name, func() error {
+		_, _, err := common.CleanupOvsPortBestEffort(ovsBridgeDriver, hostIface.Name, args.IfName, args.Netns)
+		return err
+	})
 	if err = common.AttachIfaceToBridge(ovsBridgeDriver,
 		hostIface.Name,
 		contIface.Name,
@@ -107,17 +145,21 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 		portCfg.VlanTag,
 		portCfg.Trunks,
 		portCfg.Type,
+		portCfg.QinQ,
+		portCfg.QoS,
 		netconf.InterfaceType,
 		args.Netns,
 		ovnPort,
+		common.GetOvnPortVersion(netconf, contPodUid),
 		contPodUid,
+		args.ContainerID,
+		netconf.Name,
+		common.GetPortExternalIDs(cachedNetConf, envArgs),
 	); err != nil {
-		// Unlike veth pair, OVS port will not be automatically removed
-		// if the following IPAM configuration fails and netns gets removed.
-		_, _, cleanupErr := common.CleanupOvsPortBestEffort(ovsBridgeDriver, args.IfName, args.Netns)
-		if cleanupErr != nil {
-			log.Printf("Failed best-effort cleanup: %v", cleanupErr)
-		}
+		return err
+	}
+
+	if err = common.WaitOvnInstalled(ovsBridgeDriver, netconf, hostIface.Name); err != nil {
 		return err
 	}
 
@@ -125,10 +167,31 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 		Interfaces: []*current.Interface{hostIface, contIface},
 	}
 
+	if netconf.PortSecurity {
+		rollback.Push("port security flows of "+hostIface.Name, func() error {
+			return common.RemovePortSecurity(ovsBridgeDriver, hostIface.Name)
+		})
+		if err = common.SetupPortSecurity(ovsBridgeDriver, netconf, hostIface.Name, result); err != nil {
+			return err
+		}
+	}
+
+	if err = common.RecordAttachmentAddresses(ovsBridgeDriver, hostIface.Name, result); err != nil {
+		return err
+	}
+
+	devInfoPath := deviceinfo.DeviceInfoPath(netconf.Name, cRef)
+	if err = common.SaveDeviceInfo(ovsBridgeDriver, devInfoPath, hostIface.Name, deviceInfo(netconf.DeviceID, hostIface.Name, vdpaDev)); err != nil {
+		return err
+	}
+	rollback.Push("device info "+devInfoPath, func() error {
+		return deviceinfo.CleanDeviceInfo(devInfoPath)
+	})
+
 	return cnitypes.PrintResult(result, netconf.CNIVersion)
 }
 
-func CmdDel(args *skel.CmdArgs, cache *types.CachedNetConf) error {
+func CmdDel(ctx context.Context, args *skel.CmdArgs, cache *types.CachedNetConf) error {
 	envArgs, err := common.GetEnvArgs(args.Args)
 	if err != nil {
 		return err
@@ -138,16 +201,19 @@ func CmdDel(args *skel.CmdArgs, cache *types.CachedNetConf) error {
 		ovnPort = string(envArgs.OvnPort)
 	}
 	common.ApplyConfArgsFallback(cache.Netconf, nil, &ovnPort)
-	ovsDriver, err := ovsdb.NewOvsDriver(cache.Netconf.SocketFile)
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, cache.Netconf.OvsdbConfig)
 	if err != nil {
 		return err
 	}
+	defer ovsDriver.Close()
+
 	bridgeName, err := sriov.GetBridgeName(ovsDriver, cache.Netconf.BrName, ovnPort, cache.Netconf.DeviceID)
 	if err != nil {
 		return err
 	}
 
-	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridgeName, cache.Netconf.SocketFile)
+	ovsBridgeDriver, err := ovsDriver.BridgeDriver(bridgeName)
 	if err != nil {
 		return err
 	}
@@ -161,6 +227,12 @@ func CmdDel(args *skel.CmdArgs, cache *types.CachedNetConf) error {
 		if rep, err = sriov.GetNetRepresentor(cache.Netconf.DeviceID); err != nil {
 			return err
 		}
+		if cache.Netconf.PortSecurity {
+			// the flows must not prevent the port from being removed
+			if err := common.RemovePortSecurity(ovsBridgeDriver, rep); err != nil {
+				log.Printf("Warning: %v\n", err)
+			}
+		}
 		if err = common.RemoveOvsPort(ovsBridgeDriver, rep); err != nil {
 			// Don't throw err as delete can be called multiple times because of error in ResetVF and ovs
 			// port is already deleted in a previous invocation.
@@ -169,19 +241,25 @@ func CmdDel(args *skel.CmdArgs, cache *types.CachedNetConf) error {
 		return nil
 	}
 
+	if cache.Netconf.PortSecurity {
+		// the flows must not prevent the port from being removed
+		if err := common.CleanupPortSecurity(ovsBridgeDriver, "", args.IfName, args.Netns); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+
 	// Unlike veth pair, OVS port will not be automatically removed when
 	// container namespace is gone. Find port matching DEL arguments and remove
 	// it explicitly.
-	_, _, err = common.CleanupOvsPortBestEffort(ovsBridgeDriver, args.IfName, args.Netns)
+	_, _, err = common.CleanupOvsPortBestEffort(ovsBridgeDriver, "", args.IfName, args.Netns)
 	if err != nil {
 		return err
 	}
 
-	// removes all ports whose interfaces have an error
-	return common.CleanPorts(ovsBridgeDriver)
+	return nil
 }
 
-func CmdCheck(args *skel.CmdArgs, netconf *types.NetConf) error {
+func CmdCheck(ctx context.Context, args *skel.CmdArgs, netconf *types.NetConf) error {
 	envArgs, err := common.GetEnvArgs(args.Args)
 	if err != nil {
 		return err
@@ -191,19 +269,24 @@ func CmdCheck(args *skel.CmdArgs, netconf *types.NetConf) error {
 		ovnPort = string(envArgs.OvnPort)
 	}
 	common.ApplyConfArgsFallback(netconf, nil, &ovnPort)
+	if err := common.ApplyVlanArgs(netconf, envArgs); err != nil {
+		return err
+	}
 
 	// Discover bridge name using SR-IOV specific logic
-	ovsDriver, err := ovsdb.NewOvsDriver(netconf.SocketFile)
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
 	if err != nil {
 		return err
 	}
+	defer ovsDriver.Close()
+
 	bridgeName, err := sriov.GetBridgeName(ovsDriver, netconf.BrName, ovnPort, netconf.DeviceID)
 	if err != nil {
 		return err
 	}
 	netconf.BrName = bridgeName
 
-	cache, err := common.CacheLoadAndCheck(args, netconf)
+	cache, err := common.CacheLoadAndCheck(ovsDriver, args, netconf)
 	if err != nil {
 		return err
 	}
@@ -224,5 +307,12 @@ func CmdCheck(args *skel.CmdArgs, netconf *types.NetConf) error {
 	}
 
 	// ovs specific check
-	return common.ValidateOvs(args, netconf, hostIntf.Name)
+	if err := common.ValidateOvs(ovsDriver, args, netconf, hostIntf.Name); err != nil {
+		return err
+	}
+
+	if netconf.PortSecurity {
+		return common.ValidatePortSecurity(ovsDriver, netconf, hostIntf.Name, result)
+	}
+	return nil
 }
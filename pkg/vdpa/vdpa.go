@@ -14,6 +14,7 @@ package vdpa
 
 import (
 	"fmt"
+	"log"
 	"net"
 
 	current "github.com/containernetworking/cni/pkg/types/100"
@@ -24,7 +25,9 @@ import (
 	"github.com/vishvananda/netlink"
 
 	"github.com/k8snetworkplumbingwg/govdpa/pkg/kvdpa"
+	"github.com/k8snetworkplumbingwg/sriovnet"
 
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/common"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/sriov"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 )
@@ -104,6 +107,7 @@ func setupVdpaInterface(
 	mac string,
 	vdpaDevice *kvdpa.VdpaDevice,
 	mtu int,
+	rollback *common.Rollback,
 ) (*current.Interface, *current.Interface, error) {
 	vdpaDeviceType, err := getDeviceType(vdpaDevice)
 	if err != nil {
@@ -113,7 +117,7 @@ func setupVdpaInterface(
 	case types.VdpaDeviceTypeNone:
 		return nil, nil, fmt.Errorf("non-vdpa devices can not be configured as such")
 	case types.VdpaDeviceTypeKernelVhost:
-		return setupKernelVdpaVhost(contNetns, ifName, deviceID, mac, vdpaDevice, mtu)
+		return setupKernelVdpaVhost(contNetns, ifName, deviceID, mac, vdpaDevice, mtu, rollback)
 	default:
 		return nil, nil, fmt.Errorf("unknown vdpa device type")
 	}
@@ -126,6 +130,7 @@ func setupKernelVdpaVhost(
 	mac string,
 	vdpaDevice *kvdpa.VdpaDevice,
 	mtu int,
+	rollback *common.Rollback,
 ) (*current.Interface, *current.Interface, error) {
 	hostIface := &current.Interface{}
 	contIface := &current.Interface{}
@@ -155,9 +160,16 @@ func setupKernelVdpaVhost(
 
 	// If provided, set it to the vdpa device, not the VF
 	if hwaddr != nil {
+		origMac, err := getVdpaMacAddr(vdpaDevice)
+		if err != nil {
+			return nil, nil, err
+		}
 		if err := kvdpa.SetVdpaDeviceMac((*vdpaDevice).Name(), hwaddr); err != nil {
 			return nil, nil, err
 		}
+		rollback.Push("MAC of vdpa device "+(*vdpaDevice).Name(), func() error {
+			return kvdpa.SetVdpaDeviceMac((*vdpaDevice).Name(), origMac)
+		})
 		contIface.Mac = mac
 	} else {
 		vdpaMacAddr, err := getVdpaMacAddr(vdpaDevice)
@@ -168,9 +180,13 @@ func setupKernelVdpaVhost(
 	}
 
 	if mtu != 0 {
+		origRepMTU := repLink.Attrs().MTU
 		if err = netlink.LinkSetMTU(repLink, mtu); err != nil {
 			return nil, nil, err
 		}
+		rollback.Push("MTU of "+hostIface.Name, func() error {
+			return netlink.LinkSetMTU(repLink, origRepMTU)
+		})
 
 		vfNetName, err := sriov.GetNetVF(deviceID)
 		if err != nil {
@@ -180,9 +196,13 @@ func setupKernelVdpaVhost(
 		if err != nil {
 			return nil, nil, err
 		}
+		origVfMTU := vfLink.Attrs().MTU
 		if err = netlink.LinkSetMTU(vfLink, mtu); err != nil {
 			return nil, nil, err
 		}
+		rollback.Push("MTU of VF "+vfNetName, func() error {
+			return netlink.LinkSetMTU(vfLink, origVfMTU)
+		})
 	}
 
 	contIface.Name = ifName
@@ -191,6 +211,36 @@ func setupKernelVdpaVhost(
 	return hostIface, contIface, nil
 }
 
+// deviceInfo returns the device information of a vdpa device attached
+// through the representor of its VF
+func deviceInfo(deviceID, rep string, vdpaDevice *kvdpa.VdpaDevice) *netv1.DeviceInfo {
+	pfPciAddress, err := sriovnet.GetPfPciFromVfPci(deviceID)
+	if err != nil {
+		// the device information is still useful without it
+		log.Printf("Failed to get PF of VF %s: %v", deviceID, err)
+	}
+	vdpaInfo := &netv1.VdpaDevice{
+		ParentDevice:      (*vdpaDevice).Name(),
+		PciAddress:        deviceID,
+		PfPciAddress:      pfPciAddress,
+		RepresentorDevice: rep,
+	}
+	switch (*vdpaDevice).Driver() {
+	case kvdpa.VhostVdpaDriver:
+		vdpaInfo.Driver = "vhost"
+		if vhostVdpa := (*vdpaDevice).VhostVdpa(); vhostVdpa != nil {
+			vdpaInfo.Path = vhostVdpa.Path()
+		}
+	case kvdpa.VirtioVdpaDriver:
+		vdpaInfo.Driver = "virtio"
+	}
+	return &netv1.DeviceInfo{
+		Type:    netv1.DeviceInfoTypeVDPA,
+		Version: netv1.DeviceInfoVersion,
+		Vdpa:    vdpaInfo,
+	}
+}
+
 func validateVdpaDevice(intf current.Interface, pciAddr string, vdpaType types.VdpaDeviceType) error {
 	switch vdpaType {
 	case types.VdpaDeviceTypeNone:
@@ -13,8 +13,12 @@
 package veth
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	cnitypes "github.com/containernetworking/cni/pkg/types"
@@ -25,12 +29,13 @@ import (
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/common"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/deviceinfo"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 )
 
-func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
+func CmdAdd(ctx context.Context, args *skel.CmdArgs, netconf *types.NetConf) (err error) {
 	envArgs, err := common.GetEnvArgs(args.Args)
 	if err != nil {
 		return err
@@ -45,25 +50,47 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 		contPodUid = string(envArgs.K8S_POD_UID)
 	}
 	common.ApplyConfArgsFallback(netconf, &mac, &ovnPort)
+	common.ApplyRuntimeConfigMac(netconf, &mac)
+	if err := common.ApplyVlanArgs(netconf, envArgs); err != nil {
+		return err
+	}
 
 	portCfg, err := common.ParseOvsPortConfig(netconf)
 	if err != nil {
 		return err
 	}
 
+	if err := common.CheckPortSecurityOvnPort(netconf, ovnPort); err != nil {
+		return err
+	}
+
+	common.ResolveWaitForOvnInstalled(netconf, ovnPort)
+
 	bridgeName, err := common.GetBridgeName(netconf.BrName, ovnPort)
 	if err != nil {
 		return err
 	}
 	netconf.BrName = bridgeName
 
-	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridgeName, netconf.SocketFile)
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
 	if err != nil {
 		return err
 	}
+	defer ovsDriver.Close()
 
-	// removes all ports whose interfaces have an error
-	if err := common.CleanPorts(ovsBridgeDriver); err != nil {
+	if netconf.CreateBridge {
+		if err := common.EnsureBridge(ovsDriver, bridgeName, netconf); err != nil {
+			return err
+		}
+	}
+
+	ovsBridgeDriver, err := ovsDriver.BridgeDriver(bridgeName)
+	if err != nil {
+		return err
+	}
+
+	mtu, err := common.ResolveMTU(ovsBridgeDriver, netconf)
+	if err != nil {
 		return err
 	}
 
@@ -73,17 +100,51 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 	}
 	defer func() { _ = contNetns.Close() }()
 
+	hostIfaceName, err := config.HostIfaceName(netconf, args.ContainerID, args.IfName, envArgs, hostVethPrefix)
+	if err != nil {
+		return err
+	}
+
+	// the runtime may retry ADD, e.g. after a kubelet restart
+	cachedResult, reused, err := reuseAttachment(ovsBridgeDriver, args, netconf, mac, hostIfaceName)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return cnitypes.PrintResult(cachedResult, netconf.CNIVersion)
+	}
+
+	if err = common.CheckHostIfaceName(ovsBridgeDriver, hostIfaceName); err != nil {
+		return err
+	}
+
+	// undo what has been done so far when ADD fails
+	rollback := &common.Rollback{}
+	defer func() {
+		if err != nil {
+			rollback.Run()
+		}
+	}()
+
 	// Cache NetConf for CmdDel
-	if err = utils.SaveCache(config.GetCRef(args.ContainerID, args.IfName),
-		&types.CachedNetConf{Netconf: netconf, OrigIfName: "", UserspaceMode: false}); err != nil {
+	cRef := config.GetCRef(args.ContainerID, args.IfName)
+	cachedNetConf := &types.CachedNetConf{Netconf: netconf, OrigIfName: "", UserspaceMode: false}
+	if err = config.SaveConfToCache(cRef, cachedNetConf); err != nil {
 		return fmt.Errorf("error saving NetConf %q", err)
 	}
+	rollback.Push("cache "+cRef, func() error {
+		return utils.CleanCache(cRef)
+	})
 
-	hostIface, contIface, err := SetupVeth(contNetns, args.IfName, mac, netconf.MTU)
+	hostIface, contIface, err := SetupVeth(contNetns, args.IfName, hostIfaceName, mac, mtu, rollback)
 	if err != nil {
 		return err
 	}
 
+	rollback.Push("port of "+hostIface.Name, func() error {
+		_, _, err := common.CleanupOvsPortBestEffort(ovsBridgeDriver, hostIface.Name, args.IfName, args.Netns)
+		return err
+	})
 	if err = common.AttachIfaceToBridge(
 		ovsBridgeDriver,
 		hostIface.Name,
@@ -92,29 +153,30 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 		portCfg.VlanTag,
 		portCfg.Trunks,
 		portCfg.Type,
+		portCfg.QinQ,
+		portCfg.QoS,
 		netconf.InterfaceType,
 		args.Netns,
 		ovnPort,
+		common.GetOvnPortVersion(netconf, contPodUid),
 		contPodUid,
+		args.ContainerID,
+		netconf.Name,
+		common.GetPortExternalIDs(cachedNetConf, envArgs),
 	); err != nil {
 		return err
 	}
 
-	defer func() {
-		if err != nil {
-			_, _, cleanupErr := common.CleanupOvsPortBestEffort(ovsBridgeDriver, args.IfName, args.Netns)
-			if cleanupErr != nil {
-				log.Printf("Failed best-effort cleanup: %v", cleanupErr)
-			}
-		}
-	}()
-
 	// Refetch the host interface MAC since OVS may change it when
 	// attaching the port to the bridge.
 	if err = common.RefetchIface(hostIface); err != nil {
 		return err
 	}
 
+	if err = common.WaitOvnInstalled(ovsBridgeDriver, netconf, hostIface.Name); err != nil {
+		return err
+	}
+
 	result := &current.Result{
 		Interfaces: []*current.Interface{hostIface, contIface},
 	}
@@ -126,12 +188,129 @@ func CmdAdd(args *skel.CmdArgs, netconf *types.NetConf) error {
 		if err != nil {
 			return err
 		}
+		rollback.Push("IPAM allocation", func() error {
+			return ipam.ExecDel(netconf.IPAM.Type, args.StdinData)
+		})
+	}
+
+	if netconf.PortSecurity {
+		rollback.Push("port security flows of "+hostIface.Name, func() error {
+			return common.RemovePortSecurity(ovsBridgeDriver, hostIface.Name)
+		})
+		if err = common.SetupPortSecurity(ovsBridgeDriver, netconf, hostIface.Name, result); err != nil {
+			return err
+		}
+	}
+
+	if err = common.RecordAttachmentAddresses(ovsBridgeDriver, hostIface.Name, result); err != nil {
+		return err
+	}
+
+	devInfoPath := deviceinfo.DeviceInfoPath(netconf.Name, cRef)
+	if err = common.SaveDeviceInfo(ovsBridgeDriver, devInfoPath, hostIface.Name, nil); err != nil {
+		return err
+	}
+	rollback.Push("device info "+devInfoPath, func() error {
+		return deviceinfo.CleanDeviceInfo(devInfoPath)
+	})
+
+	// Cache the result for a retried ADD
+	cachedNetConf.Result = result
+	if err = config.SaveConfToCache(cRef, cachedNetConf); err != nil {
+		return fmt.Errorf("error saving NetConf %q", err)
 	}
 
 	return cnitypes.PrintResult(result, netconf.CNIVersion)
 }
 
-func CmdDel(args *skel.CmdArgs, cache *types.CachedNetConf) error {
+// reuseAttachment looks up the attachment made by a previous ADD of the
+// container interface. Its cached result is returned when it matches netconf
+// and is still in place, otherwise it is torn down for ADD to recreate it.
+// hostIfaceName is the name of the port unless named randomly.
+func reuseAttachment(ovsDriver *ovsdb.OvsBridgeDriver, args *skel.CmdArgs, netconf *types.NetConf, mac, hostIfaceName string) (*current.Result, bool, error) {
+	cache, err := config.LoadConfFromCache(config.GetCRef(args.ContainerID, args.IfName))
+	if err != nil {
+		// no previous ADD, or one of a version not caching its result
+		cache = nil
+	}
+
+	portName, portFound, err := ovsDriver.GetOvsPortForContIface(hostIfaceName, args.IfName, args.Netns)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to obtain OVS port for given connection: %v", err)
+	}
+
+	if cache == nil && !portFound {
+		return nil, false, nil
+	}
+
+	if cache != nil && portFound && cache.Result != nil {
+		err := matchAttachment(&ovsDriver.OvsDriver, args, netconf, mac, cache)
+		if err == nil {
+			log.Printf("Info: reusing port %s attached by a previous ADD", portName)
+			return cache.Result, true, nil
+		}
+		log.Printf("Info: port %s attached by a previous ADD doesn't match: %v", portName, err)
+	}
+
+	return nil, false, removeStaleAttachment(ovsDriver, args, cache, portName, portFound)
+}
+
+// matchAttachment checks that the attachment described by the cache was made
+// with the same configuration and is in place
+func matchAttachment(ovsDriver *ovsdb.OvsDriver, args *skel.CmdArgs, netconf *types.NetConf, mac string, cache *types.CachedNetConf) error {
+	cachedConf, err := json.Marshal(cache.Netconf)
+	if err != nil {
+		return err
+	}
+	requestedConf, err := json.Marshal(netconf)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cachedConf, requestedConf) {
+		return fmt.Errorf("configuration changed")
+	}
+
+	for _, intf := range cache.Result.Interfaces {
+		if intf.Name == args.IfName && intf.Sandbox == args.Netns && mac != "" && !strings.EqualFold(intf.Mac, mac) {
+			return fmt.Errorf("mac mismatch. cache=%s,requested=%s", intf.Mac, mac)
+		}
+	}
+
+	return common.ValidateResult(ovsDriver, args, netconf, cache.Result)
+}
+
+// removeStaleAttachment removes what is left of a previous ADD, a port
+// security flows, port, container interface and IPAM allocation
+func removeStaleAttachment(ovsDriver *ovsdb.OvsBridgeDriver, args *skel.CmdArgs, cache *types.CachedNetConf, portName string, portFound bool) error {
+	if portFound {
+		if cache != nil && cache.Netconf.PortSecurity {
+			if err := common.RemovePortSecurity(ovsDriver, portName); err != nil {
+				return err
+			}
+		}
+		if err := common.RemoveOvsPort(ovsDriver, portName); err != nil {
+			return err
+		}
+	}
+
+	// the host side of the veth is removed along
+	err := ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
+		return ip.DelLinkByName(args.IfName)
+	})
+	if err != nil && err != ip.ErrLinkNotFound {
+		return fmt.Errorf("failed to remove stale interface %s: %v", args.IfName, err)
+	}
+
+	if cache != nil && cache.Netconf.IPAM.Type != "" {
+		if err := ipam.ExecDel(cache.Netconf.IPAM.Type, args.StdinData); err != nil {
+			return fmt.Errorf("failed to release stale IPAM allocation: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func CmdDel(ctx context.Context, args *skel.CmdArgs, cache *types.CachedNetConf) error {
 	envArgs, err := common.GetEnvArgs(args.Args)
 	if err != nil {
 		return err
@@ -148,10 +327,11 @@ func CmdDel(args *skel.CmdArgs, cache *types.CachedNetConf) error {
 		return err
 	}
 
-	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(bridgeName, cache.Netconf.SocketFile)
+	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(ctx, bridgeName, cache.Netconf.OvsdbConfig)
 	if err != nil {
 		return err
 	}
+	defer ovsBridgeDriver.Close()
 
 	if cache.Netconf.IPAM.Type != "" {
 		err = ipam.ExecDel(cache.Netconf.IPAM.Type, args.StdinData)
@@ -160,16 +340,26 @@ func CmdDel(args *skel.CmdArgs, cache *types.CachedNetConf) error {
 		}
 	}
 
+	// the port is looked up by name unless named randomly
+	hostIfaceName, err := config.HostIfaceName(cache.Netconf, args.ContainerID, args.IfName, envArgs, hostVethPrefix)
+	if err != nil {
+		log.Printf("Warning: looking up the port by its container interface: %v", err)
+	}
+
 	if args.Netns == "" {
 		// The CNI_NETNS parameter may be empty according to version 0.4.0
 		// of the CNI spec (https://github.com/containernetworking/cni/blob/spec-v0.4.0/SPEC.md).
-		if err := common.CleanPorts(ovsBridgeDriver); err != nil {
-			return err
+		return delWithoutNetns(ovsBridgeDriver, args, cache, hostIfaceName)
+	}
+
+	if cache.Netconf.PortSecurity {
+		// the flows must not prevent the port from being removed
+		if err := common.CleanupPortSecurity(ovsBridgeDriver, hostIfaceName, args.IfName, args.Netns); err != nil {
+			log.Printf("Warning: %v\n", err)
 		}
-		return nil
 	}
 
-	portName, portFound, err := common.CleanupOvsPortBestEffort(ovsBridgeDriver, args.IfName, args.Netns)
+	portName, portFound, err := common.CleanupOvsPortBestEffort(ovsBridgeDriver, hostIfaceName, args.IfName, args.Netns)
 	if err != nil {
 		return err
 	}
@@ -189,15 +379,10 @@ func CmdDel(args *skel.CmdArgs, cache *types.CachedNetConf) error {
 		return nil
 	}
 
-	// removes all ports whose interfaces have an error
-	if err := common.CleanPorts(ovsBridgeDriver); err != nil {
-		return err
-	}
-
 	return err
 }
 
-func CmdCheck(args *skel.CmdArgs, netconf *types.NetConf) error {
+func CmdCheck(ctx context.Context, args *skel.CmdArgs, netconf *types.NetConf) error {
 	envArgs, err := common.GetEnvArgs(args.Args)
 	if err != nil {
 		return err
@@ -207,6 +392,9 @@ func CmdCheck(args *skel.CmdArgs, netconf *types.NetConf) error {
 		ovnPort = string(envArgs.OvnPort)
 	}
 	common.ApplyConfArgsFallback(netconf, nil, &ovnPort)
+	if err := common.ApplyVlanArgs(netconf, envArgs); err != nil {
+		return err
+	}
 
 	// Discover bridge name
 	bridgeName, err := common.GetBridgeName(netconf.BrName, ovnPort)
@@ -215,8 +403,14 @@ func CmdCheck(args *skel.CmdArgs, netconf *types.NetConf) error {
 	}
 	netconf.BrName = bridgeName
 
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsDriver.Close()
+
 	// check cache
-	cache, err := common.CacheLoadAndCheck(args, netconf)
+	cache, err := common.CacheLoadAndCheck(ovsDriver, args, netconf)
 	if err != nil {
 		return err
 	}
@@ -228,5 +422,38 @@ func CmdCheck(args *skel.CmdArgs, netconf *types.NetConf) error {
 		}
 	}
 
-	return common.ValidateAttachment(args, netconf, cache)
+	return common.ValidateAttachment(ovsDriver, args, netconf, cache)
+}
+
+// delWithoutNetns cleans up an attachment whose netns isn't given on DEL, its
+// port is looked up by the container ID. The port of a named host interface
+// is removed, the port left in error by the removed veth of a randomly named
+// one is reaped by the marker. The port security flows are removed in both
+// cases.
+func delWithoutNetns(ovsDriver *ovsdb.OvsBridgeDriver, args *skel.CmdArgs, cache *types.CachedNetConf, hostIfaceName string) error {
+	port, err := ovsDriver.FindContainerPort(args.ContainerID, args.IfName)
+	if err != nil {
+		return fmt.Errorf("Failed to obtain OVS port for given connection: %v", err)
+	}
+	if port == nil {
+		return nil
+	}
+
+	if cache.Netconf.PortSecurity {
+		// the flows must not prevent the port from being removed
+		if err := common.RemovePortSecurity(ovsDriver, port.Name); err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+
+	if hostIfaceName == "" || port.Name != hostIfaceName {
+		return nil
+	}
+	if err := common.RemoveOvsPort(ovsDriver, port.Name); err != nil {
+		return err
+	}
+	if err := ip.DelLinkByName(port.Name); err != nil && err != ip.ErrLinkNotFound {
+		log.Printf("Failed best-effort cleanup of %s: %v", port.Name, err)
+	}
+	return nil
 }
@@ -22,6 +22,10 @@ import (
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/common"
 )
 
+// hostVethPrefix is the prefix of the host veth names, the one of the random
+// names given by ip.SetupVeth
+const hostVethPrefix = "veth"
+
 func setInterfaceUp(name string) error {
 	link, err := netlink.LinkByName(name)
 	if err != nil {
@@ -35,7 +39,10 @@ func setInterfaceUp(name string) error {
 	return nil
 }
 
-func SetupVeth(contNetns ns.NetNS, contIfaceName string, requestedMac string, mtu int) (*current.Interface, *current.Interface, error) {
+// SetupVeth creates a veth pair with one end in the container namespace and
+// the other in the host one, its removal is recorded in rollback. The host
+// end is named randomly when hostIfaceName is empty.
+func SetupVeth(contNetns ns.NetNS, contIfaceName string, hostIfaceName string, requestedMac string, mtu int, rollback *common.Rollback) (*current.Interface, *current.Interface, error) {
 	hostIface := &current.Interface{}
 	contIface := &current.Interface{}
 
@@ -43,10 +50,14 @@ func SetupVeth(contNetns ns.NetNS, contIfaceName string, requestedMac string, mt
 	// this we will make sure that both ends of the veth pair will be removed
 	// when the container is gone.
 	err := contNetns.Do(func(hostNetns ns.NetNS) error {
-		hostVeth, containerVeth, err := ip.SetupVeth(contIfaceName, mtu, requestedMac, hostNetns)
+		hostVeth, containerVeth, err := ip.SetupVethWithName(contIfaceName, hostIfaceName, mtu, requestedMac, hostNetns)
 		if err != nil {
 			return err
 		}
+		// the host end is removed along with the container one
+		rollback.Push("veth "+contIfaceName, func() error {
+			return removeContIface(contNetns, contIfaceName)
+		})
 
 		if err := setInterfaceUp(contIfaceName); err != nil {
 			return err
@@ -69,3 +80,14 @@ func SetupVeth(contNetns ns.NetNS, contIfaceName string, requestedMac string, mt
 
 	return hostIface, contIface, nil
 }
+
+// removeContIface removes the container end of a veth pair, if any
+func removeContIface(contNetns ns.NetNS, contIfaceName string) error {
+	err := contNetns.Do(func(ns.NetNS) error {
+		return ip.DelLinkByName(contIfaceName)
+	})
+	if err != nil && err != ip.ErrLinkNotFound {
+		return err
+	}
+	return nil
+}
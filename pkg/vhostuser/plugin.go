@@ -0,0 +1,338 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhostuser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ipam"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/common"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/deviceinfo"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
+)
+
+func CmdAdd(ctx context.Context, args *skel.CmdArgs, netconf *types.NetConf) (err error) {
+	envArgs, err := common.GetEnvArgs(args.Args)
+	if err != nil {
+		return err
+	}
+
+	var mac string
+	var ovnPort string
+	var contPodUid string
+	if envArgs != nil {
+		mac = string(envArgs.MAC)
+		ovnPort = string(envArgs.OvnPort)
+		contPodUid = string(envArgs.K8S_POD_UID)
+	}
+	common.ApplyConfArgsFallback(netconf, &mac, &ovnPort)
+	common.ApplyRuntimeConfigMac(netconf, &mac)
+	if err := common.ApplyVlanArgs(netconf, envArgs); err != nil {
+		return err
+	}
+
+	portCfg, err := common.ParseOvsPortConfig(netconf)
+	if err != nil {
+		return err
+	}
+
+	common.ResolveWaitForOvnInstalled(netconf, ovnPort)
+
+	bridgeName, err := common.GetBridgeName(netconf.BrName, ovnPort)
+	if err != nil {
+		return err
+	}
+	netconf.BrName = bridgeName
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsDriver.Close()
+
+	if netconf.CreateBridge {
+		if err := common.EnsureBridge(ovsDriver, bridgeName, netconf); err != nil {
+			return err
+		}
+	}
+
+	ovsBridgeDriver, err := ovsDriver.BridgeDriver(bridgeName)
+	if err != nil {
+		return err
+	}
+
+	// vhost-user ports are only handled by the userspace datapath
+	datapathType, err := ovsBridgeDriver.GetBridgeDatapathType(bridgeName)
+	if err != nil {
+		return fmt.Errorf("failed to get datapath type of bridge %s: %v", bridgeName, err)
+	}
+	if datapathType != netdevDatapath {
+		return fmt.Errorf("portMode %s requires a bridge of the %s datapath, bridge %s has %q",
+			netconf.PortMode, netdevDatapath, bridgeName, datapathType)
+	}
+
+	// undo what has been done so far when ADD fails
+	rollback := &common.Rollback{}
+	defer func() {
+		if err != nil {
+			rollback.Run()
+		}
+	}()
+
+	// Cache NetConf for CmdDel
+	cRef := config.GetCRef(args.ContainerID, args.IfName)
+	cachedNetConf := &types.CachedNetConf{Netconf: netconf, OrigIfName: "", UserspaceMode: false}
+	if err = config.SaveConfToCache(cRef, cachedNetConf); err != nil {
+		return fmt.Errorf("error saving NetConf %q", err)
+	}
+	rollback.Push("cache "+cRef, func() error {
+		return utils.CleanCache(cRef)
+	})
+
+	socketPath := SocketPath(netconf, args.ContainerID, args.IfName)
+	if err = os.MkdirAll(SocketDir(netconf, args.ContainerID), 0755); err != nil {
+		return fmt.Errorf("failed to create vhost-user socket directory: %v", err)
+	}
+	rollback.Push("vhost-user socket "+socketPath, func() error {
+		return removeSocket(socketPath)
+	})
+
+	portName, err := randomPortName()
+	if err != nil {
+		return err
+	}
+
+	if err = ovsBridgeDriver.CreatePort(
+		portName,
+		args.Netns,
+		args.IfName,
+		ovnPort,
+		common.GetOvnPortVersion(netconf, contPodUid),
+		netconf.OfportRequest,
+		portCfg.VlanTag,
+		portCfg.Trunks,
+		portCfg.Type,
+		portCfg.QinQ,
+		portCfg.QoS,
+		vhostUserClientType,
+		map[string]string{vhostServerPathOption: socketPath},
+		contPodUid,
+		args.ContainerID,
+		netconf.Name,
+		common.GetPortExternalIDs(cachedNetConf, envArgs),
+	); err != nil {
+		return err
+	}
+
+	rollback.Push("port "+portName, func() error {
+		return ovsBridgeDriver.DeletePort(portName)
+	})
+
+	if err = common.WaitOvnInstalled(ovsBridgeDriver, netconf, portName); err != nil {
+		return err
+	}
+
+	// the host interface refers to the OVS port, the container interface to
+	// the socket the pod has to serve. Nothing is plumbed in the container
+	// namespace.
+	hostIface := &current.Interface{Name: portName}
+	contIface := &current.Interface{
+		Name:       args.IfName,
+		Mac:        mac,
+		Sandbox:    args.Netns,
+		SocketPath: socketPath,
+	}
+
+	result := &current.Result{
+		Interfaces: []*current.Interface{hostIface, contIface},
+	}
+
+	// addresses are only reported, they are configured by the application
+	// serving the socket
+	if netconf.IPAM.Type != "" {
+		var r cnitypes.Result
+		r, err = ipam.ExecAdd(netconf.IPAM.Type, args.StdinData)
+		if err != nil {
+			return fmt.Errorf("failed to set up IPAM plugin type %q: %v", netconf.IPAM.Type, err)
+		}
+		rollback.Push("IPAM allocation", func() error {
+			return ipam.ExecDel(netconf.IPAM.Type, args.StdinData)
+		})
+
+		var ipamResult *current.Result
+		ipamResult, err = current.NewResultFromResult(r)
+		if err != nil {
+			return err
+		}
+		if len(ipamResult.IPs) == 0 {
+			err = errors.New("IPAM plugin returned missing IP config")
+			return err
+		}
+
+		for _, ipc := range ipamResult.IPs {
+			// All addresses apply to the container interface
+			ipc.Interface = current.Int(1)
+		}
+		result.IPs = ipamResult.IPs
+		result.Routes = ipamResult.Routes
+		result.DNS = ipamResult.DNS
+	}
+
+	if err = common.RecordAttachmentAddresses(ovsBridgeDriver, portName, result); err != nil {
+		return err
+	}
+
+	devInfoPath := deviceinfo.DeviceInfoPath(netconf.Name, cRef)
+	if err = common.SaveDeviceInfo(ovsBridgeDriver, devInfoPath, portName, deviceInfo(socketPath)); err != nil {
+		return err
+	}
+	rollback.Push("device info "+devInfoPath, func() error {
+		return deviceinfo.CleanDeviceInfo(devInfoPath)
+	})
+
+	return cnitypes.PrintResult(result, netconf.CNIVersion)
+}
+
+func CmdDel(ctx context.Context, args *skel.CmdArgs, cache *types.CachedNetConf) error {
+	envArgs, err := common.GetEnvArgs(args.Args)
+	if err != nil {
+		return err
+	}
+
+	var ovnPort string
+	if envArgs != nil {
+		ovnPort = string(envArgs.OvnPort)
+	}
+	common.ApplyConfArgsFallback(cache.Netconf, nil, &ovnPort)
+
+	bridgeName, err := common.GetBridgeName(cache.Netconf.BrName, ovnPort)
+	if err != nil {
+		return err
+	}
+
+	ovsBridgeDriver, err := ovsdb.NewOvsBridgeDriver(ctx, bridgeName, cache.Netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsBridgeDriver.Close()
+
+	if cache.Netconf.IPAM.Type != "" {
+		err = ipam.ExecDel(cache.Netconf.IPAM.Type, args.StdinData)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The port is looked up by its socket, which doesn't depend on the
+	// CNI_NETNS parameter, possibly empty on DEL.
+	socketPath := SocketPath(cache.Netconf, args.ContainerID, args.IfName)
+	portName, portFound, err := ovsBridgeDriver.FindInterfaceByOption(vhostServerPathOption, socketPath)
+	if err != nil {
+		return fmt.Errorf("Failed to obtain OVS port for given connection: %v", err)
+	}
+	if portFound {
+		if err := common.RemoveOvsPort(ovsBridgeDriver, portName); err != nil {
+			return err
+		}
+	}
+
+	return removeSocket(socketPath)
+}
+
+func CmdCheck(ctx context.Context, args *skel.CmdArgs, netconf *types.NetConf) error {
+	envArgs, err := common.GetEnvArgs(args.Args)
+	if err != nil {
+		return err
+	}
+	var ovnPort string
+	if envArgs != nil {
+		ovnPort = string(envArgs.OvnPort)
+	}
+	common.ApplyConfArgsFallback(netconf, nil, &ovnPort)
+	if err := common.ApplyVlanArgs(netconf, envArgs); err != nil {
+		return err
+	}
+
+	// Discover bridge name
+	bridgeName, err := common.GetBridgeName(netconf.BrName, ovnPort)
+	if err != nil {
+		return err
+	}
+	netconf.BrName = bridgeName
+
+	ovsDriver, err := ovsdb.NewOvsDriver(ctx, netconf.OvsdbConfig)
+	if err != nil {
+		return err
+	}
+	defer ovsDriver.Close()
+
+	// check cache
+	if _, err := common.CacheLoadAndCheck(ovsDriver, args, netconf); err != nil {
+		return err
+	}
+
+	// run the IPAM plugin
+	if netconf.NetConf.IPAM.Type != "" {
+		if err := ipam.ExecCheck(netconf.NetConf.IPAM.Type, args.StdinData); err != nil {
+			return fmt.Errorf("failed to check with IPAM plugin type %q: %v", netconf.NetConf.IPAM.Type, err)
+		}
+	}
+
+	result, err := common.ParsePrevResult(netconf)
+	if err != nil {
+		return err
+	}
+
+	var hostIntf, contIntf current.Interface
+	for _, intf := range result.Interfaces {
+		if intf.Sandbox == "" {
+			hostIntf = *intf
+		} else if intf.Name == args.IfName {
+			contIntf = *intf
+		}
+	}
+	if args.Netns != contIntf.Sandbox {
+		return fmt.Errorf("Sandbox in prevResult %s doesn't match configured netns: %s",
+			contIntf.Sandbox, args.Netns)
+	}
+
+	socketPath := SocketPath(netconf, args.ContainerID, args.IfName)
+	if contIntf.SocketPath != socketPath {
+		return fmt.Errorf("Error: socket path in prevResult %s doesn't match expected socket path %s",
+			contIntf.SocketPath, socketPath)
+	}
+
+	// ovs specific check
+	if err := common.ValidateOvs(ovsDriver, args, netconf, hostIntf.Name); err != nil {
+		return err
+	}
+
+	serverPath, err := ovsDriver.GetInterfaceOption(hostIntf.Name, vhostServerPathOption)
+	if err != nil {
+		return fmt.Errorf("Error: Failed to retrieve interface %s options: %v", hostIntf.Name, err)
+	}
+	if serverPath != socketPath {
+		return fmt.Errorf("Error: interface %s connects to socket %s, expected %s", hostIntf.Name, serverPath, socketPath)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhostuser
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	netv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+)
+
+const (
+	// vhostUserClientType is the interface type of a vhost-user port, where
+	// vswitchd connects as a client to the socket served by the pod
+	vhostUserClientType = "dpdkvhostuserclient"
+	// vhostServerPathOption is the interface option holding the socket path
+	vhostServerPathOption = "vhost-server-path"
+	// netdevDatapath is the userspace datapath of OVS-DPDK bridges
+	netdevDatapath = "netdev"
+)
+
+// IsVhostUser returns true when the pod is attached with a vhost-user socket
+// rather than a netdev in its namespace
+func IsVhostUser(netconf *types.NetConf) bool {
+	return netconf.PortMode == types.PortModeVhostUser
+}
+
+// SocketDir returns the directory holding the vhost-user sockets of a pod
+func SocketDir(netconf *types.NetConf, containerID string) string {
+	return filepath.Join(netconf.VhostUserSocketDir, containerID)
+}
+
+// SocketPath returns the path of the vhost-user socket of a pod interface
+func SocketPath(netconf *types.NetConf, containerID, ifName string) string {
+	return filepath.Join(SocketDir(netconf, containerID), ifName)
+}
+
+// randomPortName returns a random name of the OVS vhost-user port
+func randomPortName() (string, error) {
+	entropy := make([]byte, 4)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", fmt.Errorf("failed to generate random port name: %v", err)
+	}
+	return fmt.Sprintf("vhu%x", entropy), nil
+}
+
+// deviceInfo returns the device information of a vhost-user socket. vswitchd
+// is the client, hence the pod must serve the socket.
+func deviceInfo(socketPath string) *netv1.DeviceInfo {
+	return &netv1.DeviceInfo{
+		Type:    netv1.DeviceInfoTypeVHostUser,
+		Version: netv1.DeviceInfoVersion,
+		VhostUser: &netv1.VhostDevice{
+			Mode: netv1.VhostDeviceModeServer,
+			Path: socketPath,
+		},
+	}
+}
+
+// removeSocket removes the socket of a pod interface, along with the pod
+// socket directory once it is empty
+func removeSocket(socketPath string) error {
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove vhost-user socket %s: %v", socketPath, err)
+	}
+
+	// other interfaces of the pod may still use the directory, which is
+	// reported as an already existing error
+	if err := os.Remove(filepath.Dir(socketPath)); err != nil && !os.IsNotExist(err) && !os.IsExist(err) {
+		return fmt.Errorf("failed to remove vhost-user socket directory: %v", err)
+	}
+	return nil
+}
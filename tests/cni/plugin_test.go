@@ -16,10 +16,12 @@ package cni
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -42,8 +44,12 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/common"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/deviceinfo"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/ovsdb"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/plugin"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/portsecurity"
 	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/utils"
 )
 
 type Range struct {
@@ -67,29 +73,51 @@ type IPAMConfig struct {
 }
 
 type Net040 struct {
-	CNIVersion    string                 `json:"cniVersion"`
-	Name          string                 `json:"name"`
-	Type          string                 `json:"type"`
-	Bridge        string                 `json:"bridge"`
-	IPAM          *IPAMConfig            `json:"ipam"`
-	VlanTag       *uint                  `json:"vlan"`
-	Trunk         []*types.Trunk         `json:"trunk,omitempty"`
-	InterfaceType string                 `json:"interface_type"`
-	RawPrevResult map[string]interface{} `json:"prevResult,omitempty"`
-	PrevResult    types040.Result        `json:"-"`
+	CNIVersion           string                 `json:"cniVersion"`
+	Name                 string                 `json:"name"`
+	Type                 string                 `json:"type"`
+	Bridge               string                 `json:"bridge"`
+	IPAM                 *IPAMConfig            `json:"ipam"`
+	VlanTag              *uint                  `json:"vlan"`
+	MTU                  types.MTU              `json:"mtu,omitempty"`
+	MTUOverhead          int                    `json:"mtuOverhead,omitempty"`
+	Trunk                []*types.Trunk         `json:"trunk,omitempty"`
+	VlanMode             string                 `json:"vlanMode,omitempty"`
+	Cvlans               []*types.Trunk         `json:"cvlans,omitempty"`
+	QinqEthtype          string                 `json:"qinqEthtype,omitempty"`
+	InterfaceType        string                 `json:"interface_type"`
+	PortMode             string                 `json:"portMode,omitempty"`
+	IngressPolicingRate  uint                   `json:"ingress_policing_rate,omitempty"`
+	IngressPolicingBurst uint                   `json:"ingress_policing_burst,omitempty"`
+	EgressShapingRate    uint64                 `json:"egress_shaping_rate,omitempty"`
+	EgressShapingBurst   uint64                 `json:"egress_shaping_burst,omitempty"`
+	PortSecurity         bool                   `json:"portSecurity,omitempty"`
+	RawPrevResult        map[string]interface{} `json:"prevResult,omitempty"`
+	PrevResult           types040.Result        `json:"-"`
 }
 
 type NetCurrent struct {
-	CNIVersion    string                 `json:"cniVersion"`
-	Name          string                 `json:"name"`
-	Type          string                 `json:"type"`
-	Bridge        string                 `json:"bridge"`
-	IPAM          *IPAMConfig            `json:"ipam"`
-	VlanTag       *uint                  `json:"vlan"`
-	Trunk         []*types.Trunk         `json:"trunk,omitempty"`
-	InterfaceType string                 `json:"interface_type"`
-	RawPrevResult map[string]interface{} `json:"prevResult,omitempty"`
-	PrevResult    current.Result         `json:"-"`
+	CNIVersion           string                 `json:"cniVersion"`
+	Name                 string                 `json:"name"`
+	Type                 string                 `json:"type"`
+	Bridge               string                 `json:"bridge"`
+	IPAM                 *IPAMConfig            `json:"ipam"`
+	VlanTag              *uint                  `json:"vlan"`
+	MTU                  types.MTU              `json:"mtu,omitempty"`
+	MTUOverhead          int                    `json:"mtuOverhead,omitempty"`
+	Trunk                []*types.Trunk         `json:"trunk,omitempty"`
+	VlanMode             string                 `json:"vlanMode,omitempty"`
+	Cvlans               []*types.Trunk         `json:"cvlans,omitempty"`
+	QinqEthtype          string                 `json:"qinqEthtype,omitempty"`
+	InterfaceType        string                 `json:"interface_type"`
+	PortMode             string                 `json:"portMode,omitempty"`
+	IngressPolicingRate  uint                   `json:"ingress_policing_rate,omitempty"`
+	IngressPolicingBurst uint                   `json:"ingress_policing_burst,omitempty"`
+	EgressShapingRate    uint64                 `json:"egress_shaping_rate,omitempty"`
+	EgressShapingBurst   uint64                 `json:"egress_shaping_burst,omitempty"`
+	PortSecurity         bool                   `json:"portSecurity,omitempty"`
+	RawPrevResult        map[string]interface{} `json:"prevResult,omitempty"`
+	PrevResult           current.Result         `json:"-"`
 }
 
 const pluginVlanID = 100
@@ -103,6 +131,68 @@ var _ = Describe("CNI Plugin 0.3.1", func() { pluginTestFunc("0.3.1") })
 var _ = Describe("CNI Plugin 0.4.0", func() { pluginTestFunc("0.4.0") })
 var _ = Describe("CNI Plugin 1.0.0", func() { pluginTestFunc("1.0.0") })
 
+var _ = Describe("CNI Plugin STATUS", func() {
+	testStatus := func(conf string) error {
+		args := &skel.CmdArgs{
+			StdinData: []byte(conf),
+		}
+		return plugin.CmdStatus(args)
+	}
+
+	Context("when ovsdb is reachable", func() {
+		BeforeEach(func() {
+			output, err := exec.Command("ovs-vsctl", "add-br", pluginBridgeName).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), "Failed to create testing OVS bridge: %v", string(output[:]))
+		})
+
+		AfterEach(func() {
+			output, err := exec.Command("ovs-vsctl", "del-br", pluginBridgeName).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), "Failed to remove testing OVS bridge: %v", string(output[:]))
+		})
+
+		It("should succeed when the bridge exists", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "1.1.0",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, pluginBridgeName)
+			Expect(testStatus(conf)).To(Succeed())
+		})
+
+		It("should report the plugin as not available when the bridge is missing", func() {
+			conf := `{
+				"cniVersion": "1.1.0",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "missing-bridge"
+			}`
+			err := testStatus(conf)
+			Expect(err).To(HaveOccurred())
+			cniErr, ok := err.(*cnitypes.Error)
+			Expect(ok).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(plugin.ErrPluginNotAvailable))
+		})
+	})
+
+	Context("when ovsdb is not reachable", func() {
+		It("should report the plugin as not available", func() {
+			conf := `{
+				"cniVersion": "1.1.0",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "mynet0",
+				"socket_file": "unix:/tmp/ovs-cni-missing-db.sock"
+			}`
+			err := testStatus(conf)
+			Expect(err).To(HaveOccurred())
+			cniErr, ok := err.(*cnitypes.Error)
+			Expect(ok).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(plugin.ErrPluginNotAvailable))
+		})
+	})
+})
+
 var pluginTestFunc = func(version string) {
 	testCheck := func(conf string, r cnitypes.Result, targetNs ns.NetNS) {
 		if checkSupported, _ := cniversion.GreaterThanOrEqualTo(version, "0.4.0"); !checkSupported {
@@ -234,6 +324,18 @@ var pluginTestFunc = func(version string) {
 		})
 		Expect(err).NotTo(HaveOccurred())
 
+		By("Checking that the addresses are recorded on the OVS interface")
+		output, err := exec.Command("ovs-vsctl", "get", "Interface", result.Interfaces[0].Name, "external_ids:attached-mac").CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(output))
+		Expect(strings.Trim(string(output), "\"\n")).To(Equal(result.Interfaces[1].Mac))
+		output, err = exec.Command("ovs-vsctl", "get", "Interface", result.Interfaces[0].Name, "external_ids:ip_addresses").CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(output))
+		var addresses []string
+		for _, ipc := range result.IPs {
+			addresses = append(addresses, ipc.Address.String())
+		}
+		Expect(strings.Trim(string(output), "\"\n")).To(Equal(strings.Join(addresses, ",")))
+
 		By("Calling CHECK command")
 		testCheck(conf, r, targetNs)
 
@@ -284,6 +386,10 @@ var pluginTestFunc = func(version string) {
 		brPorts, err := listBridgePorts(netconf.BrName)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(len(brPorts)).To(Equal(0))
+
+		By("Checking that the device info file was removed")
+		_, err = os.Stat(deviceinfo.DeviceInfoPath(netconf.Name, config.GetCRef(args.ContainerID, args.IfName)))
+		Expect(os.IsNotExist(err)).To(BeTrue())
 	}
 
 	testAdd := func(conf string, setVlan, setMtu bool, Trunk string, targetNs ns.NetNS) (string, cnitypes.Result) {
@@ -357,6 +463,15 @@ var pluginTestFunc = func(version string) {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(externalIDContNetns).To(Equal("\"" + targetNs.Path() + "\""))
 
+		By("Checking that the device info file refers to the port")
+		devInfoBytes, err := os.ReadFile(deviceinfo.DeviceInfoPath(netconf.Name, config.GetCRef(args.ContainerID, args.IfName)))
+		Expect(err).NotTo(HaveOccurred())
+		devInfo := &deviceinfo.OvsDeviceInfo{}
+		Expect(json.Unmarshal(devInfoBytes, devInfo)).To(Succeed())
+		Expect(devInfo.Ovs).NotTo(BeNil())
+		Expect(devInfo.Ovs.Bridge).To(Equal(netconf.BrName))
+		Expect(devInfo.Ovs.Port).To(Equal(hostIface.Name))
+
 		By("Verifying situation inside the container")
 		err = targetNs.Do(func(ns.NetNS) error {
 			defer GinkgoRecover()
@@ -418,6 +533,17 @@ var pluginTestFunc = func(version string) {
 		brPorts, err := listBridgePorts(netconf.BrName)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(brPorts).To(Equal(originalBrPorts))
+
+		By("Checking that the container interface was removed")
+		err = targetNs.Do(func(ns.NetNS) error {
+			_, err := netlink.LinkByName(pluginIFNAME)
+			return err
+		})
+		Expect(err).To(HaveOccurred())
+
+		By("Checking that the cached NetConf was removed")
+		_, err = config.LoadConfFromCache(config.GetCRef(args.ContainerID, args.IfName))
+		Expect(err).To(HaveOccurred())
 	}
 
 	Context("connecting container to a bridge", func() {
@@ -493,6 +619,64 @@ var pluginTestFunc = func(version string) {
 				testDel(conf, hostIfName, targetNs, true)
 			})
 		})
+		Context("with VLAN ID requested through args", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": 10,
+				"allowedVlans": [ {"minID": 10, "maxID": %d} ],
+				"args": {"cni": {"vlan": %d}}
+			}`, version, pluginBridgeName, pluginVlanID, pluginVlanID)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, true, false, "", targetNs)
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with a VLAN ID requested through args which isn't allowed", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": 10,
+				"allowedVlans": [ {"id": 10} ],
+				"args": {"cni": {"vlan": %d}}
+			}`, version, pluginBridgeName, pluginVlanID)
+			It("should fail with ADD", func() {
+				testInvalidAdd(conf)
+			})
+		})
+		Context("with host interfaces named after the attachments", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"hostIfaceNaming": {"mode": "hash", "prefix": "ovs"}
+			}`, version, pluginBridgeName)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				netconf, err := config.LoadConf([]byte(conf))
+				Expect(err).NotTo(HaveOccurred())
+				expectedName, err := config.HostIfaceName(netconf, "dummy", pluginIFNAME, nil, "veth")
+				Expect(err).NotTo(HaveOccurred())
+
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+				Expect(hostIfName).To(Equal(expectedName))
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
 		Context("without a VLAN ID set on port", func() {
 			conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
@@ -624,6 +808,473 @@ var pluginTestFunc = func(version string) {
 				testDel(conf, hostIfName, targetNs, true)
 			})
 		})
+		Context("with bandwidth limits set on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"ingress_policing_rate": 1000,
+				"ingress_policing_burst": 100,
+				"egress_shaping_rate": 2000000,
+				"egress_shaping_burst": 200000
+			}`, version, pluginBridgeName)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+
+				By("Checking that ingress policing is configured on the interface")
+				output, err := exec.Command("ovs-vsctl", "get", "Interface", hostIfName, "ingress_policing_rate", "ingress_policing_burst").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.Fields(string(output))).To(Equal([]string{"1000", "100"}))
+
+				By("Checking that egress shaping QoS is referenced by the port")
+				qos, err := getPortAttribute(hostIfName, "qos")
+				Expect(err).NotTo(HaveOccurred())
+				output, err = exec.Command("ovs-vsctl", "get", "QoS", qos, "type", "other_config:max-rate").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.Fields(string(output))).To(Equal([]string{"linux-htb", "\"2000000\""}))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+
+				By("Checking that QoS and Queue rows were removed")
+				for _, table := range []string{"QoS", "Queue"} {
+					output, err = exec.Command("ovs-vsctl", "--columns=_uuid", "find", table, "external_ids:owner=ovs-cni.network.kubevirt.io").CombinedOutput()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(strings.TrimSpace(string(output))).To(BeEmpty())
+				}
+			})
+		})
+		Context("with native-untagged vlanMode set on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlanMode": "native-untagged",
+				"vlan": %d,
+				"trunk": [ {"id": 200}, {"id": 300} ]
+			}`, version, pluginBridgeName, pluginVlanID)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, true, false, "[200, 300]", targetNs)
+
+				By("Checking that the port vlan_mode is native-untagged")
+				vlanMode, err := getPortAttribute(hostIfName, "vlan_mode")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vlanMode).To(Equal("native-untagged"))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with dot1q-tunnel vlanMode set on port", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlanMode": "dot1q-tunnel",
+				"vlan": %d,
+				"cvlans": [ {"minID": 10, "maxID": 11} ],
+				"qinqEthtype": "802.1q"
+			}`, version, pluginBridgeName, pluginVlanID)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, true, false, "", targetNs)
+
+				By("Checking that the port is configured as dot1q-tunnel")
+				vlanMode, err := getPortAttribute(hostIfName, "vlan_mode")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vlanMode).To(Equal("dot1q-tunnel"))
+				cvlans, err := getPortAttribute(hostIfName, "cvlans")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cvlans).To(Equal("[10, 11]"))
+				ethtype, err := getPortAttribute(hostIfName, "other_config:qinq-ethtype")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ethtype).To(Equal("\"802.1q\""))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with vhost-user portMode on a bridge of the system datapath", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"portMode": "vhost-user"
+			}`, version, pluginBridgeName)
+			It("should fail and leave no leftovers", func() {
+				testInvalidAdd(conf)
+			})
+		})
+		Context("with internal portMode", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"portMode": "internal",
+				"vlan": %d
+			}`, version, pluginBridgeName, pluginVlanID)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      pluginIFNAME,
+					StdinData:   []byte(conf),
+				}
+
+				By("Calling ADD command")
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return plugin.CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Interfaces).To(HaveLen(2))
+				hostIfName := result.Interfaces[0].Name
+
+				By("Checking that the OVS interface is of type internal")
+				output, err := exec.Command("ovs-vsctl", "get", "Interface", hostIfName, "type").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.TrimSpace(string(output))).To(Equal("internal"))
+				portVlan, err := getPortAttribute(hostIfName, "tag")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(portVlan).To(Equal(strconv.Itoa(pluginVlanID)))
+
+				By("Checking that the internal port netdev was moved to the container")
+				_, err = netlink.LinkByName(hostIfName)
+				Expect(err).To(HaveOccurred())
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					contLink, err := netlink.LinkByName(pluginIFNAME)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(contLink.Type()).To(Equal("openvswitch"))
+					Expect(contLink.Attrs().HardwareAddr.String()).To(Equal(result.Interfaces[1].Mac))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				testCheck(conf, r, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with mac set in the runtime config", func() {
+			const runtimeMac = "0a:58:0a:01:02:ff"
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"args": {
+					"cni": {
+						"mac": "0a:58:0a:01:02:fe"
+					}
+				},
+				"runtimeConfig": {
+					"mac": "%s"
+				}
+			}`, version, pluginBridgeName, runtimeMac)
+			It("should assign the requested MAC over the one from args", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      pluginIFNAME,
+					StdinData:   []byte(conf),
+				}
+
+				By("Calling ADD command")
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return plugin.CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Interfaces[1].Mac).To(Equal(runtimeMac))
+
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					contLink, err := netlink.LinkByName(pluginIFNAME)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(contLink.Attrs().HardwareAddr.String()).To(Equal(runtimeMac))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				testCheck(conf, r, targetNs)
+				testDel(conf, result.Interfaces[0].Name, targetNs, true)
+			})
+		})
+		Context("with ADD retried for the same container interface", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, pluginBridgeName)
+			vlanConf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"vlan": %d
+			}`, version, pluginBridgeName, pluginVlanID)
+			retryAdd := func(conf string, targetNs ns.NetNS) *current.Result {
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      pluginIFNAME,
+					StdinData:   []byte(conf),
+				}
+				By("Calling ADD command again")
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return plugin.CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				return result
+			}
+			It("should return the result of the previous ADD", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, r := testAdd(conf, false, false, "", targetNs)
+				firstResult, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+
+				result := retryAdd(conf, targetNs)
+				Expect(result.Interfaces).To(Equal(firstResult.Interfaces))
+
+				By("Checking that no other port was attached to the bridge")
+				brPorts, err := listBridgePorts(pluginBridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(brPorts).To(Equal([]string{hostIfName}))
+
+				testCheck(conf, r, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+			It("should recreate the attachment when the configuration changed", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				staleHostIfName, _ := testAdd(conf, false, false, "", targetNs)
+
+				result := retryAdd(vlanConf, targetNs)
+				hostIfName := result.Interfaces[0].Name
+				Expect(hostIfName).NotTo(Equal(staleHostIfName))
+
+				By("Checking that the stale port was replaced")
+				brPorts, err := listBridgePorts(pluginBridgeName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(brPorts).To(Equal([]string{hostIfName}))
+				portVlan, err := getPortAttribute(hostIfName, "tag")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(portVlan).To(Equal(strconv.Itoa(pluginVlanID)))
+				_, err = netlink.LinkByName(staleHostIfName)
+				Expect(err).To(HaveOccurred())
+
+				testDel(vlanConf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with mtu auto set on port", func() {
+			const uplinkName = "mtu-uplink0"
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"mtu": "auto",
+				"mtuOverhead": 50
+			}`, version, pluginBridgeName)
+			BeforeEach(func() {
+				uplink := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: uplinkName, MTU: 9000}}
+				Expect(netlink.LinkAdd(uplink)).To(Succeed())
+				output, err := exec.Command("ovs-vsctl", "add-port", pluginBridgeName, uplinkName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), "Failed to add uplink port: %v", string(output[:]))
+			})
+			AfterEach(func() {
+				output, err := exec.Command("ovs-vsctl", "--if-exists", "del-port", uplinkName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), "Failed to remove uplink port: %v", string(output[:]))
+				if link, err := netlink.LinkByName(uplinkName); err == nil {
+					Expect(netlink.LinkDel(link)).To(Succeed())
+				}
+			})
+			It("should derive the MTU from the uplink and complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      pluginIFNAME,
+					StdinData:   []byte(conf),
+				}
+
+				By("Calling ADD command")
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return plugin.CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				hostIfName := result.Interfaces[0].Name
+
+				By("Checking that both veth ends use the uplink MTU minus the overhead")
+				hostLink, err := netlink.LinkByName(hostIfName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hostLink.Attrs().MTU).To(Equal(8950))
+				err = targetNs.Do(func(ns.NetNS) error {
+					defer GinkgoRecover()
+
+					contLink, err := netlink.LinkByName(pluginIFNAME)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(contLink.Attrs().MTU).To(Equal(8950))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				testCheck(conf, r, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
+		Context("with createBridge set for a missing bridge", func() {
+			const createdBridgeName = "test-created-br"
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"createBridge": true,
+				"failMode": "standalone"
+			}`, version, createdBridgeName)
+			AfterEach(func() {
+				output, err := exec.Command("ovs-vsctl", "--if-exists", "del-br", createdBridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), "Failed to remove created OVS bridge: %v", string(output[:]))
+			})
+			It("should create the bridge and complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+
+				By("Checking that the bridge was created with the requested fail mode")
+				output, err := exec.Command("ovs-vsctl", "get-fail-mode", createdBridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.TrimSpace(string(output))).To(Equal("standalone"))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+			It("should handle concurrent creation of the same bridge", func() {
+				netconf, err := config.LoadConf([]byte(conf))
+				Expect(err).NotTo(HaveOccurred())
+
+				errs := make(chan error, 5)
+				for i := 0; i < cap(errs); i++ {
+					go func() {
+						ovsDriver, err := ovsdb.NewOvsDriver(context.Background(), netconf.OvsdbConfig)
+						if err != nil {
+							errs <- err
+							return
+						}
+						defer ovsDriver.Close()
+						errs <- common.EnsureBridge(ovsDriver, createdBridgeName, netconf)
+					}()
+				}
+				for i := 0; i < cap(errs); i++ {
+					Expect(<-errs).NotTo(HaveOccurred())
+				}
+
+				output, err := exec.Command("ovs-vsctl", "br-exists", createdBridgeName).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			})
+		})
+		Context("with port security enabled", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s",
+				"portSecurity": true
+			}`, version, pluginBridgeName)
+			It("should successfully complete ADD, CHECK and DEL commands", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+
+				dumpPortFlows := func() string {
+					cookie := fmt.Sprintf("cookie=%#x/-1", portsecurity.Cookie(hostIfName))
+					output, err := exec.Command("ovs-ofctl", "dump-flows", "--no-names", pluginBridgeName, cookie).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred())
+					return string(output)
+				}
+
+				By("Checking that only the container MAC address is allowed from the port")
+				r, err := current.GetResult(result)
+				Expect(err).NotTo(HaveOccurred())
+				contMac := r.Interfaces[1].Mac
+				flows := dumpPortFlows()
+				Expect(flows).To(ContainSubstring("dl_src=" + contMac))
+				Expect(flows).To(ContainSubstring("actions=drop"))
+
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+
+				By("Checking that the port security flows were removed")
+				Expect(dumpPortFlows()).NotTo(ContainSubstring("cookie="))
+			})
+			It("should remove the flows on DEL without a netns", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, _ := testAdd(conf, false, false, "", targetNs)
+
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					IfName:      pluginIFNAME,
+					StdinData:   []byte(conf),
+				}
+				Expect(cmdDelWithArgs(args, func() error {
+					return plugin.CmdDel(args)
+				})).To(Succeed())
+
+				cookie := fmt.Sprintf("cookie=%#x/-1", portsecurity.Cookie(hostIfName))
+				output, err := exec.Command("ovs-ofctl", "dump-flows", "--no-names", pluginBridgeName, cookie).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(output)).NotTo(ContainSubstring("cookie="))
+			})
+		})
 		Context("invoke DEL action after deleting container net namespace", func() {
 			conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
@@ -667,6 +1318,25 @@ var pluginTestFunc = func(version string) {
 				testDel(conf, hostIfName, targetNs, true)
 			})
 		})
+		Context("invoke DEL action after losing the cached netconf", func() {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"
+			}`, version, pluginBridgeName)
+			It("should recover the attachment from ovsdb to check and delete it", func() {
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+				hostIfName, result := testAdd(conf, false, false, "", targetNs)
+				testCheck(conf, result, targetNs)
+				Expect(utils.CleanCache(config.GetCRef("dummy", pluginIFNAME))).To(Succeed())
+				testCheck(conf, result, targetNs)
+				testDel(conf, hostIfName, targetNs, true)
+			})
+		})
 		Context("random mac address on container interface", func() {
 			It("should create eth0 on two different namespace with different mac addresses", func() {
 				conf := fmt.Sprintf(`{
@@ -723,7 +1393,6 @@ var pluginTestFunc = func(version string) {
 		})
 		Context("specified OvnPort", func() {
 			It("should configure and ovs interface with iface-id", func() {
-				const ovsOutput = "external_ids        : {iface-id=test-port}"
 
 				conf := fmt.Sprintf(`{
 				"cniVersion": "%s",
@@ -740,11 +1409,112 @@ var pluginTestFunc = func(version string) {
 				OvnPort := "test-port"
 				result := pluginAttach(targetNs, conf, pluginIFNAME, "", OvnPort)
 				hostIface := result.Interfaces[0]
+				// the interface also records the container MAC address
+				ovsOutput := fmt.Sprintf("external_ids        : {attached-mac=%q, iface-id=test-port}", result.Interfaces[1].Mac)
 				output, err := exec.Command("ovs-vsctl", "--column=external_ids", "find", "Interface", fmt.Sprintf("name=%s", hostIface.Name)).CombinedOutput()
 				Expect(err).NotTo(HaveOccurred())
 				Expect(string(output[:len(output)-1])).To(Equal(ovsOutput))
 			})
 		})
+		Context("specified pod", func() {
+			It("should record the pod on the ovs port", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"bridge": "%s"}`, version, pluginBridgeName)
+
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      pluginIFNAME,
+					StdinData:   []byte(conf),
+					Args:        "K8S_POD_NAMESPACE=test-namespace;K8S_POD_NAME=test-pod",
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return plugin.CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				hostIface := result.Interfaces[0]
+				for key, value := range map[string]string{"contPodNamespace": "test-namespace", "contPodName": "test-pod", "netName": "mynet"} {
+					output, err := exec.Command("ovs-vsctl", "get", "Port", hostIface.Name, "external_ids:"+key).CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+					Expect(strings.Trim(string(output), "\"\n")).To(Equal(value))
+				}
+				output, err := exec.Command("ovs-vsctl", "get", "Interface", hostIface.Name, "external_ids:attached-mac").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				Expect(strings.Trim(string(output), "\"\n")).To(Equal(result.Interfaces[1].Mac))
+			})
+		})
+		Context("waiting for ovn-installed", func() {
+			It("should complete ADD once ovn-controller has installed the port", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"waitForOvnInstalled": true,
+				"bridge": "%s"}`, version, pluginBridgeName)
+
+				targetNs := newNS()
+				defer func() {
+					closeNS(targetNs)
+				}()
+
+				// stand in for ovn-controller
+				installed := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(installed)
+					var intfName string
+					Eventually(func() (string, error) {
+						output, err := exec.Command("ovs-vsctl", "--bare", "--columns=name", "find", "Interface", "external_ids:iface-id=test-port").CombinedOutput()
+						intfName = strings.TrimSpace(string(output))
+						return intfName, err
+					}, 10*time.Second, 100*time.Millisecond).ShouldNot(BeEmpty())
+					output, err := exec.Command("ovs-vsctl", "set", "Interface", intfName, "external_ids:ovn-installed=true").CombinedOutput()
+					Expect(err).NotTo(HaveOccurred(), string(output))
+				}()
+
+				args := &skel.CmdArgs{
+					ContainerID: "dummy",
+					Netns:       targetNs.Path(),
+					IfName:      pluginIFNAME,
+					StdinData:   []byte(conf),
+					Args:        "OvnPort=test-port;K8S_POD_UID=pod-uid",
+				}
+				r, _, err := cmdAddWithArgs(args, func() error {
+					return plugin.CmdAdd(args)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				<-installed
+
+				result, err := current.GetResult(r)
+				Expect(err).NotTo(HaveOccurred())
+				hostIface := result.Interfaces[0]
+				output, err := exec.Command("ovs-vsctl", "get", "Interface", hostIface.Name, "external_ids:iface-id-ver").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.TrimSpace(string(output))).To(Equal(`pod-uid`))
+			})
+			It("should fail with ADD when ovn-controller doesn't install the port", func() {
+				conf := fmt.Sprintf(`{
+				"cniVersion": "%s",
+				"name": "mynet",
+				"type": "ovs",
+				"waitForOvnInstalled": true,
+				"ovnInstalledTimeout": 1,
+				"args": {"cni": {"ovnPort": "test-port"}},
+				"bridge": "%s"}`, version, pluginBridgeName)
+				testInvalidAdd(conf)
+			})
+		})
 		Context("specified OfportRequest", func() {
 			It("should configure an ovs interface with a specific ofport", func() {
 				// Pick a random ofport 5000-6000
@@ -842,8 +1612,11 @@ var pluginTestFunc = func(version string) {
 				}
 				netconf, err := config.LoadConf(args.StdinData)
 				Expect(err).NotTo(HaveOccurred())
+				ovsDriver, err := ovsdb.NewOvsDriver(context.Background(), netconf.OvsdbConfig)
+				Expect(err).NotTo(HaveOccurred())
+				defer ovsDriver.Close()
 
-				err = common.ValidateOvs(args, netconf, hostIfName)
+				err = common.ValidateOvs(ovsDriver, args, netconf, hostIfName)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("error state"))
 			})
@@ -918,6 +1691,111 @@ var pluginTestFunc = func(version string) {
 	})
 }
 
+var _ = Describe("CNI Plugin GC", func() {
+	conf := fmt.Sprintf(`{
+		"cniVersion": "1.1.0",
+		"name": "mynet",
+		"type": "ovs",
+		"bridge": "%s"
+	}`, pluginBridgeName)
+
+	BeforeEach(func() {
+		output, err := exec.Command("ovs-vsctl", "add-br", pluginBridgeName).CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), "Failed to create testing OVS bridge: %v", string(output[:]))
+	})
+
+	AfterEach(func() {
+		output, err := exec.Command("ovs-vsctl", "del-br", pluginBridgeName).CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), "Failed to remove testing OVS bridge: %v", string(output[:]))
+	})
+
+	attach := func(containerID string, targetNs ns.NetNS) *current.Result {
+		args := &skel.CmdArgs{
+			ContainerID: containerID,
+			Netns:       targetNs.Path(),
+			IfName:      pluginIFNAME,
+			StdinData:   []byte(conf),
+		}
+		r, _, err := cmdAddWithArgs(args, func() error {
+			return plugin.CmdAdd(args)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		result, err := current.GetResult(r)
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	It("should remove ports of attachments which are not valid anymore", func() {
+		validNs := newNS()
+		defer func() {
+			closeNS(validNs)
+		}()
+		staleNs := newNS()
+		defer func() {
+			closeNS(staleNs)
+		}()
+
+		validResult := attach("valid", validNs)
+		staleResult := attach("stale", staleNs)
+
+		gcConf := fmt.Sprintf(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ovs",
+			"bridge": "%s",
+			"cni.dev/valid-attachments": [{"containerID": "valid", "ifname": "%s"}]
+		}`, pluginBridgeName, pluginIFNAME)
+		args := &skel.CmdArgs{
+			StdinData: []byte(gcConf),
+		}
+		Expect(plugin.CmdGC(args)).To(Succeed())
+
+		brPorts, err := listBridgePorts(pluginBridgeName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(brPorts).To(ConsistOf(validResult.Interfaces[0].Name))
+
+		By("Checking that host side of the stale veth pair was deleted")
+		_, err = netlink.LinkByName(staleResult.Interfaces[0].Name)
+		Expect(err).To(HaveOccurred())
+
+		By("Checking that the stale cached NetConf was removed")
+		_, err = config.LoadConfFromCache(config.GetCRef("stale", pluginIFNAME))
+		Expect(err).To(HaveOccurred())
+		_, err = config.LoadConfFromCache(config.GetCRef("valid", pluginIFNAME))
+		Expect(err).NotTo(HaveOccurred())
+
+		By("Checking that the stale device info file was removed")
+		_, err = os.Stat(deviceinfo.DeviceInfoPath("mynet", config.GetCRef("stale", pluginIFNAME)))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		_, err = os.Stat(deviceinfo.DeviceInfoPath("mynet", config.GetCRef("valid", pluginIFNAME)))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should remove ports of older versions whose netns is gone", func() {
+		legacyPort := "legacyport"
+		output, err := exec.Command("ovs-vsctl", "add-port", pluginBridgeName, legacyPort,
+			"--", "set", "Interface", legacyPort, "type=internal",
+			"--", "set", "Port", legacyPort,
+			"external_ids:owner=ovs-cni.network.kubevirt.io",
+			"external_ids:contNetns=/var/run/netns/ovs-cni-removed-netns",
+			"external_ids:contIface="+pluginIFNAME).CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), "Failed to add the legacy port: %v", string(output[:]))
+
+		gcConf := fmt.Sprintf(`{
+			"cniVersion": "1.1.0",
+			"name": "mynet",
+			"type": "ovs",
+			"bridge": "%s",
+			"cni.dev/valid-attachments": []
+		}`, pluginBridgeName)
+		Expect(plugin.CmdGC(&skel.CmdArgs{StdinData: []byte(gcConf)})).To(Succeed())
+
+		brPorts, err := listBridgePorts(pluginBridgeName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(brPorts).NotTo(ContainElement(legacyPort))
+	})
+})
+
 func pluginAttach(namespace ns.NetNS, conf, ifName, mac, ovnPort string) *current.Result {
 	extraArgs := ""
 	if mac != "" {
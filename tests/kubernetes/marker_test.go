@@ -16,6 +16,8 @@ package tests_test
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -23,6 +25,7 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/k8snetworkplumbingwg/ovs-cni/pkg/portsecurity"
 	"github.com/k8snetworkplumbingwg/ovs-cni/tests/kubernetes/node"
 )
 
@@ -62,6 +65,35 @@ var _ = Describe("ovs-cni-marker", func() {
 		})
 	})
 
+	Describe("ports in error state", func() {
+		It("should remove the port along with its port security flows", func() {
+			workerNode := node.WorkerNode()
+			const portName = "reaped0"
+			cookie := fmt.Sprintf("cookie=%#x/-1", portsecurity.Cookie(portName))
+
+			node.AddOvsBridgeOnNode("br-test")
+			defer node.RemoveOvsBridgeOnNode("br-test")
+
+			// the interface of the port doesn't exist, like the one of a
+			// veth removed along with the container network namespace
+			out, err := node.RunAtNode(workerNode, "ovs-vsctl", "add-port", "br-test", portName,
+				"--", "set", "Port", portName, "external_ids:owner=ovs-cni.network.kubevirt.io")
+			Expect(err).NotTo(HaveOccurred(), "Failed adding a port in error state: %v: %v", err, out)
+			out, err = node.RunAtNode(workerNode, "ovs-ofctl", "add-flow", "br-test",
+				fmt.Sprintf("cookie=%#x,priority=100,actions=drop", portsecurity.Cookie(portName)))
+			Expect(err).NotTo(HaveOccurred(), "Failed adding a flow of the port: %v: %v", err, out)
+
+			Eventually(func() (string, error) {
+				out, err := node.RunAtNode(workerNode, "ovs-vsctl", "--columns=name", "find", "Port", "name="+portName)
+				return strings.TrimSpace(out), err
+			}, 180*time.Second, 5*time.Second).Should(BeEmpty(), "Port in error state should have been removed")
+
+			out, err = node.RunAtNode(workerNode, "ovs-ofctl", "dump-flows", "br-test", cookie)
+			Expect(err).NotTo(HaveOccurred(), "Failed dumping the flows of the port: %v: %v", err, out)
+			Expect(out).NotTo(ContainSubstring("cookie="))
+		})
+	})
+
 	Describe("Health Check", func() {
 		It("Should not restart ovs-cni-marker container", func() {
 			Consistently(func() bool {